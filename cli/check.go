@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"keys/internal/keymap"
+)
+
+// Check reports problems in a config file and the files it includes. It
+// exits with 1 if any of them stop a key or option from working, so it can
+// be used before installing a new config.
+func Check(stdout io.Writer, stderr io.Writer, filename string) int {
+	diagnostics, err := keymap.CheckFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read config: %s\n", err)
+		return 1
+	}
+
+	for _, d := range diagnostics {
+		if d.Line > 0 {
+			fmt.Fprintf(stdout, "%s:%s\n", d.File, d)
+		} else {
+			fmt.Fprintf(stdout, "%s: %s\n", d.File, d)
+		}
+	}
+
+	if keymap.HasErrors(diagnostics) {
+		return 1
+	}
+
+	if len(diagnostics) == 0 {
+		fmt.Fprintf(stdout, "%s: no problems found\n", filename)
+	}
+
+	return 0
+}
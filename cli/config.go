@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"keys/internal/importer"
+	"keys/internal/keymap"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Config manages the config file itself rather than using it, so it doesn't
+// need the file to load.
+func Config(stdout io.Writer, stderr io.Writer, filename string, args []string) int {
+	var subcommand string
+	if len(args) > 0 {
+		subcommand = args[0]
+	}
+
+	switch subcommand {
+	case "rollback":
+		return Rollback(stdout, stderr, filename, args[1:])
+	case "convert":
+		return Convert(stdout, stderr, filename, args[1:])
+	case "import":
+		return Import(stdout, stderr, filename, args[1:])
+	default:
+		fmt.Fprintln(stderr, "Config command not specified. Run keys --help for available commands.")
+		return 1
+	}
+}
+
+// Rollback restores the config from N saves ago, or the one before the
+// current one.
+func Rollback(stdout io.Writer, stderr io.Writer, filename string, args []string) int {
+	steps := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			fmt.Fprintf(stderr, "%q is not a number of saves to go back.\n", args[0])
+			return 1
+		}
+		steps = n
+	}
+
+	revision, err := keymap.Rollback(filename, steps)
+	if errors.Is(err, keymap.ErrNoRevision) {
+		fmt.Fprintf(stderr, "There are not enough revisions in %s to go back %d.\n", keymap.HistoryDir(filename), steps)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not roll back: %s\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Restored %s from %s. Restart the server if it is running.\n", filename, revision.Time.Local().Format("2006-01-02 15:04:05"))
+	return 0
+}
+
+// Convert writes a config file out in the format given by the extension of
+// the new file. Comments aren't carried over, so the original is kept and
+// an existing file isn't replaced.
+func Convert(stdout io.Writer, stderr io.Writer, filename string, args []string) int {
+	input := filename
+	var output string
+
+	switch len(args) {
+	case 1:
+		output = args[0]
+	case 2:
+		input, output = args[0], args[1]
+	default:
+		fmt.Fprintln(stderr, "Give the file to write, such as keys.json, and optionally the file to read before it.")
+		return 1
+	}
+
+	if _, err := os.Stat(output); err == nil {
+		fmt.Fprintf(stderr, "%s already exists.\n", output)
+		return 1
+	}
+
+	raw, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read %s: %s\n", input, err)
+		return 1
+	}
+
+	converted, err := keymap.Convert(keymap.FormatFor(input), keymap.FormatFor(output), raw)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not convert %s: %s\n", input, err)
+		return 1
+	}
+
+	if err := os.WriteFile(output, converted, 0600); err != nil {
+		fmt.Fprintf(stderr, "Could not write %s: %s\n", output, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Wrote %s. Files it includes are left as they are. Use --config to start keys with it.\n", output)
+	return 0
+}
+
+// Import adds the bindings in another tool's config file to the config.
+// Anything that can't be carried over is listed rather than dropped
+// quietly, and nothing is added if the config can't take all the rest.
+func Import(stdout io.Writer, stderr io.Writer, filename string, args []string) int {
+	flags := flag.NewFlagSet("config import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	from := flags.String("from", "", "Tool the file is for: "+strings.Join(importer.Tools, ", "))
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "Give the file to import and the tool it is for, such as --from %s ~/.config/sxhkd/sxhkdrc.\n", importer.Tools[0])
+		return 1
+	}
+	input := flags.Arg(0)
+
+	raw, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read %s: %s\n", input, err)
+		return 1
+	}
+
+	bindings, problems, err := importer.Import(*from, raw)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not import %s: %s\n", input, err)
+		return 1
+	}
+
+	km, err := keymap.NewKeymap(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not load %s: %s\n", filename, err)
+		return 1
+	}
+
+	sections, more := importer.Sections(bindings, km)
+	problems = append(problems, more...)
+	slices.SortStableFunc(problems, func(a, b importer.Problem) int {
+		return a.Line - b.Line
+	})
+
+	for _, problem := range problems {
+		fmt.Fprintf(stderr, "%s:%s\n", input, problem)
+	}
+
+	if len(sections) == 0 {
+		fmt.Fprintf(stderr, "Nothing in %s could be imported.\n", input)
+		return 1
+	}
+
+	if err := km.AddKeys(sections); err != nil {
+		fmt.Fprintf(stderr, "Could not add the keys to %s: %s\n", filename, err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Added %d keys to %s", len(sections), filename)
+	if len(problems) > 0 {
+		fmt.Fprintf(stdout, ", leaving out the %d listed above", len(problems))
+	}
+	fmt.Fprintln(stdout, ". Restart the server if it is running.")
+
+	return 0
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"keys/internal/export"
+	"keys/internal/keymap"
+	"os"
+	"strings"
+)
+
+// Export writes the keymap as a cheat sheet to print, to FILE or stdout.
+// The format is taken from the extension of FILE unless --format is given.
+func Export(stdout io.Writer, stderr io.Writer, km *keymap.Keymap, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "", "One of "+strings.Join(export.Formats, ", ")+". Defaults to the extension of FILE, or pdf")
+	gridName := flags.String("grid", "", "Layout of the device: "+strings.Join(keymap.LayoutNames, ", ")+". Defaults to the layout of the keymap, or "+export.DefaultGrid)
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if flags.NArg() > 1 {
+		fmt.Fprintln(stderr, "Give at most one file to write.")
+		return 1
+	}
+	output := flags.Arg(0)
+
+	if *format == "" {
+		*format = "pdf"
+		if output != "" {
+			var err error
+			if *format, err = export.FormatFor(output); err != nil {
+				fmt.Fprintf(stderr, "Could not export: %s\n", err)
+				return 1
+			}
+		}
+	}
+
+	grid, err := export.FindGrid(km, *gridName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not export: %s\n", err)
+		return 1
+	}
+
+	w := stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not write %s: %s\n", output, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := export.Write(w, *format, export.Sheets(km, grid), grid); err != nil {
+		fmt.Fprintf(stderr, "Could not export: %s\n", err)
+		return 1
+	}
+
+	if output != "" {
+		fmt.Fprintf(stdout, "Wrote %s. Print it at 100%% scale for the cards to match the keys.\n", output)
+	}
+
+	return 0
+}
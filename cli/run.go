@@ -28,12 +28,6 @@ func Run(stdout io.Writer, stderr io.Writer) int {
 		return 0
 	}
 
-	cfg, err := config.NewConfig(*configFlag)
-	if err != nil {
-		fmt.Fprintln(stderr, "Could not parse config. Giving up.")
-		return 1
-	}
-
 	command := flag.Arg(0)
 
 	var args []string
@@ -41,7 +35,29 @@ func Run(stdout io.Writer, stderr io.Writer) int {
 		args = flag.Args()[1:]
 	}
 
+	// Checking doesn't need a config that loads, since finding out why one
+	// doesn't is the point.
+	if command == "check" {
+		filename := *configFlag
+		if len(args) > 0 {
+			filename = args[0]
+		}
+		return Check(stdout, stderr, filename)
+	}
+
+	if command == "config" {
+		return Config(stdout, stderr, *configFlag, args)
+	}
+
+	cfg, err := config.NewConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, "Could not parse config. Giving up.")
+		return 1
+	}
+
 	switch command {
+	case "export":
+		return Export(stdout, stderr, cfg.Keymap, args)
 	case "test":
 		return Test(cfg, args)
 	case "setup":
@@ -65,6 +81,28 @@ Usage
   %s [COMMAND]
 
 Commands
+  check [FILE]
+        Look for mistakes in a config file, by default the one given by --config.
+
+  config rollback [N]
+        Restore the config file from N saves ago. Defaults to 1, the save
+        before the current one. Running it again undoes the rollback.
+
+  config convert [FILE] NEWFILE
+        Write the config file, or FILE, in the format of NEWFILE: .ini,
+        .json, .toml, .yaml or .yml. Comments are not carried over.
+
+  config import --from TOOL FILE
+        Add the bindings in FILE, a config file of sxhkd or xbindkeys, to
+        the config file. Modifiers become keys pressed before the rest, and
+        anything that can't be carried over is listed.
+
+  export [FILE]
+        Write a cheat sheet of the keys to print, as PDF, SVG or HTML by the
+        extension of FILE, or as PDF to stdout. Cards are placed like the keys
+        of the keymap's layout, or of --grid 3x3, 4x4 or numpad, and --format
+        chooses the format.
+
   select keyboard
         Choose which physical keyboard to use for input.
 
@@ -16,21 +16,22 @@ func Start(cfg *config.Config, args []string) int {
 	flagSet = flag.NewFlagSet("server", flag.ExitOnError)
 
 	port := flagSet.Int("port", 4004, "Web server port")
-	inputs := flagSet.String("inputs", "browser,keyboard", "Where to listen for input")
+	inputs := flagSet.String("inputs", "browser,keyboard,schedule", "Where to listen for input")
 
 	flagSet.Usage = startUsage
 	if err := flagSet.Parse(args); err != nil {
 		log.Println(err)
 	}
 
-	cfg.PublicUrl = fmt.Sprintf("http://localhost:%d", *port)
+	cfg.SetPublicUrl(fmt.Sprintf("http://localhost:%d", *port))
+	cfg.TriggerUrl = fmt.Sprintf("http://127.0.0.1:%d", *port)
 
 	if strings.Contains(*inputs, "keyboard") {
 		go device.Listen(cfg, nil)
 	}
 
 	if strings.Contains(*inputs, "browser") {
-		server.Serve(cfg, *port)
+		server.Serve(cfg, *port, strings.Contains(*inputs, "schedule"))
 	}
 
 	return 0
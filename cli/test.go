@@ -21,7 +21,7 @@ func Test(cfg *config.Config, args []string) int {
 
 	switch testName {
 	case "sound":
-		TestSound()
+		TestSound(cfg)
 	case "key":
 		TestKey(cfg)
 	}
@@ -49,24 +49,58 @@ func TestKey(cfg *config.Config) {
 	device.Listen(cfg, callback)
 }
 
-func TestSound() {
-	sounds := map[string]sound.Name{
-		"Confirmation": sound.Confirmation,
-		"Error":        sound.Error,
-		"Lock":         sound.Lock,
-		"Unlock":       sound.Unlock,
+type soundPreview struct {
+	label string
+	play  func() error
+}
+
+// TestSound plays the built-in sounds, or the files configured in their
+// place, followed by any sounds given to individual keys.
+func TestSound(cfg *config.Config) {
+	cfg.ConfigureSound()
+
+	names := []struct {
+		label string
+		name  sound.Name
+	}{
+		{"Confirmation", sound.Confirmation},
+		{"Error", sound.Error},
+		{"Lock", sound.Lock},
+		{"Unlock", sound.Unlock},
+		{"Prompt", sound.Prompt},
+	}
+
+	var previews []soundPreview
+	for _, n := range names {
+		name := n.name
+		previews = append(previews, soundPreview{
+			label: fmt.Sprintf("the %s sound (%s)", n.label, sound.Source(name)),
+			play:  func() error { return sound.Play(name) },
+		})
+	}
+
+	for key := range cfg.Keymap.Keys() {
+		if key.Sound == "" {
+			continue
+		}
+
+		path, volume := key.Sound, key.SoundVolume
+		previews = append(previews, soundPreview{
+			label: fmt.Sprintf("the sound for %s (%s)", key.Name, path),
+			play:  func() error { return sound.PlayFile(path, volume) },
+		})
 	}
 
 	for {
-		for name, s := range sounds {
-			fmt.Printf("Press ENTER to play the %s sound ", name)
+		for _, preview := range previews {
+			fmt.Printf("Press ENTER to play %s ", preview.label)
 			_, err := fmt.Scanln()
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			if err := sound.Play(s); err != nil {
-				log.Fatal(err)
+			if err := preview.play(); err != nil {
+				log.Println(err)
 			}
 		}
 
@@ -1,6 +1,14 @@
 let keyBuffer = '';
 let keyTimer = 0;
 
+/** @type {Record<string, number>} */
+const jobStatusCodes = {
+    'succeeded': 200,
+    'cancelled': 409,
+    'failed': 500,
+    'timed out': 504,
+};
+
 window.addEventListener('click', (e) => {
     const target = e.target;
     if (target instanceof HTMLAnchorElement === false) return;
@@ -10,12 +18,22 @@ window.addEventListener('click', (e) => {
         window.dispatchEvent(new CustomEvent('app:clear'));
     }
 
+    if (target.classList.contains('cancel') && target.dataset.name) {
+        e.preventDefault();
+        cancelRunning(target.dataset.name);
+    }
+
     if (target.classList.contains('key')) {
         e.preventDefault();
-        window.dispatchEvent(new CustomEvent('app:start'));
+        triggerKey(target);
+    }
 
-        // Give the start message some time to display and not flicker.
-        setTimeout(() => runTrigger(target), 500);
+    // Mapped keys of the layout trigger the key of the list, which has what
+    // triggering needs. Unmapped ones link to the editor.
+    if (target.classList.contains('layout-key') && target.dataset.name) {
+        e.preventDefault();
+        const key = document.querySelector(`#keys a.key[data-name="${CSS.escape(target.dataset.name)}"]`);
+        if (key instanceof HTMLAnchorElement) triggerKey(key);
     }
 });
 
@@ -32,17 +50,19 @@ window.addEventListener('keyup', (e) => {
 
     if (['Control', 'Alt', 'Tab'].indexOf(e.key) > -1) return;
 
+    if (e.target instanceof HTMLElement) {
+        const tag = e.target.nodeName;
+        if (['INPUT', 'SELECT', 'TEXTAREA'].indexOf(tag) > -1) return;
+    }
+
+    if (document.querySelector('dialog[open]')) return;
+
     if (e.key === 'E') {
         const node = document.querySelector('form.edit');
         if (node instanceof HTMLFormElement) node.submit();
         return;
     }
 
-    if (e.target instanceof HTMLElement) {
-        const tag = e.target.nodeName;
-        if (['INPUT', 'SELECT', 'TEXTAREA'].indexOf(tag) > -1) return;
-    }
-
     if (document.querySelector('#keys.locked')) return;
 
     keyBuffer += e.key;
@@ -68,7 +88,7 @@ window.addEventListener('keyup', (e) => {
 });
 
 window.addEventListener('app:cancel', () => {
-    const node = document.querySelector('#cancel');
+    const node = document.querySelector('#cancel, #status a.cancel:not(.hidden)');
     if (node instanceof HTMLAnchorElement) node.click();
 });
 
@@ -80,8 +100,15 @@ window.addEventListener('app:clear', () => {
     }
 });
 
-window.addEventListener('app:start', () => {
+window.addEventListener('app:start', (e) => {
     setStatus('Running…', 'start');
+
+    if (e instanceof CustomEvent === false) return;
+    const cancelEl = document.querySelector('#status a.cancel');
+    if (cancelEl instanceof HTMLAnchorElement && e.detail.node instanceof HTMLAnchorElement) {
+        cancelEl.dataset.name = e.detail.node.dataset.name;
+        cancelEl.classList.remove('hidden');
+    }
 });
 
 window.addEventListener('app:success', (e) => {
@@ -103,20 +130,403 @@ window.addEventListener('app:success', (e) => {
 
 window.addEventListener('app:fail', (e) => {
     if (e instanceof CustomEvent === false) return;
-    const message = e.detail.status < 500 ? e.detail.result : 'Service Unavailable';
+    const message = e.detail.status < 500 || e.detail.status === 504 ? e.detail.result : 'Service Unavailable';
     setStatus(message, 'fail');
 });
 
 window.addEventListener('DOMContentLoaded', () => {
-    const el = document.getElementById('save');
+    const list = document.getElementById('section-list');
+    const data = document.getElementById('sections-data');
+    if (list instanceof HTMLOListElement === false || data === null) return;
+
+    /** @type {KeySection[]} */
+    const sections = JSON.parse(data.textContent || 'null') || [];
+    list.replaceChildren(...sections.map((section) => sectionForm(section, true)));
+
+    /** @param {string} physicalKey */
+    const addKey = (physicalKey) => {
+        const li = sectionForm({
+            name: '',
+            options: [{ name: 'physical_key', value: physicalKey }, { name: 'command', value: '' }],
+        }, false);
+        list.append(li);
+        li.querySelector('input')?.focus();
+    };
+
+    document.getElementById('add-key')?.addEventListener('click', () => addKey(''));
+
+    // The unmapped keys of the layout link here to start a key for them.
+    const physicalKey = new URLSearchParams(window.location.search).get('physical_key');
+    if (physicalKey) addKey(physicalKey);
+
+    document.getElementById('add-row')?.addEventListener('click', () => {
+        const li = sectionForm({ name: '--', options: [] }, false);
+        list.append(li);
+        li.querySelector('input')?.focus();
+    });
+});
+
+window.addEventListener('DOMContentLoaded', () => {
+    const textarea = document.querySelector('#raw textarea');
+    if (textarea instanceof HTMLTextAreaElement === false) return;
+
+    for (const el of document.querySelectorAll('#diagnostics .line')) {
+        if (el instanceof HTMLButtonElement === false) continue;
+        el.addEventListener('click', () => selectLine(textarea, Number.parseInt(el.dataset.line || '1', 10)));
+    }
+});
+
+window.addEventListener('DOMContentLoaded', () => {
+    const el = document.getElementById('config-sound');
     if (el instanceof HTMLButtonElement === false) return;
 
-    el.addEventListener('click', () => {
-        const node = document.querySelector('#editor form')
-        if (node instanceof HTMLFormElement) node.submit();
+    el.addEventListener('click', async () => {
+        const wanted = el.classList.contains('on') ? 'off' : 'on';
+        const response = await fetch('/settings/sound', {
+            method: 'POST',
+            body: new URLSearchParams({ sound: wanted }),
+        });
+
+        if (!response.ok) {
+            setStatus(renderOutput(await response.text(), 'text/plain'), 'fail');
+            return;
+        }
+
+        const settings = await response.json();
+        showSound(Boolean(settings.sound));
+    });
+});
+
+window.addEventListener('DOMContentLoaded', () => {
+    const el = document.querySelector('#config-layout select');
+    if (el instanceof HTMLSelectElement === false) return;
+
+    el.addEventListener('change', async () => {
+        const response = await fetch('/settings/layout', {
+            method: 'POST',
+            body: new URLSearchParams({ layout: el.value }),
+        });
+
+        if (!response.ok) {
+            setStatus(renderOutput(await response.text(), 'text/plain'), 'fail');
+            return;
+        }
+
+        window.location.reload();
     });
 });
 
+window.addEventListener('DOMContentLoaded', () => {
+    const layout = document.getElementById('layout');
+    if (layout === null) return;
+
+    // The layout is a grid of quarter keys. Inline styles aren't allowed by
+    // the content security policy, but setting them from here is.
+    /** @param {string | undefined} value */
+    const quarters = (value) => Math.round(Number.parseFloat(value || '0') * 4);
+
+    let columns = 1;
+    let rows = 1;
+    for (const el of layout.querySelectorAll('.layout-key')) {
+        if (el instanceof HTMLElement === false) continue;
+        const x = quarters(el.dataset.x);
+        const y = quarters(el.dataset.y);
+        const width = Math.max(1, quarters(el.dataset.w));
+        const height = Math.max(1, quarters(el.dataset.h));
+
+        el.style.gridColumn = `${x + 1} / span ${width}`;
+        el.style.gridRow = `${y + 1} / span ${height}`;
+        columns = Math.max(columns, x + width);
+        rows = Math.max(rows, y + height);
+    }
+
+    layout.style.gridTemplateColumns = `repeat(${columns}, minmax(0, 1fr))`;
+    layout.style.gridTemplateRows = `repeat(${rows}, 1em)`;
+    layout.style.maxWidth = `${columns}em`;
+});
+
+window.addEventListener('DOMContentLoaded', () => {
+    for (const el of document.querySelectorAll('a.key[data-probe-interval]')) {
+        if (el instanceof HTMLAnchorElement === false) continue;
+        const interval = Number.parseInt(el.dataset.probeInterval || '', 10);
+        if (interval > 0) setInterval(() => refreshState(el), interval);
+    }
+});
+
+/**
+ * @typedef {{name: string, value: string}} Option
+ * @typedef {{name: string, options: Option[], position?: number, file?: string}} KeySection
+ * @typedef {{field: string, index: number, message: string}} FieldError
+ */
+
+/**
+ * Build the form for one key or row of the structured editor.
+ *
+ * @param {KeySection} section
+ * @param {boolean} saved Whether the section is already in the config file.
+ */
+function sectionForm(section, saved) {
+    const template = document.getElementById('section-form');
+    if (template instanceof HTMLTemplateElement === false) throw new Error('missing section template');
+
+    const li = /** @type {HTMLLIElement} */ (template.content.firstElementChild?.cloneNode(true));
+    if (saved) li.dataset.name = section.name;
+    li.classList.toggle('row', section.name.startsWith('--'));
+
+    const nameEl = /** @type {HTMLInputElement} */ (li.querySelector('.section-name'));
+    nameEl.value = section.name;
+    nameEl.addEventListener('input', () => li.classList.toggle('row', nameEl.value.startsWith('--')));
+
+    const options = /** @type {HTMLOListElement} */ (li.querySelector('.options'));
+    options.replaceChildren(...section.options.map(optionRow));
+
+    li.querySelector('.add-option')?.addEventListener('click', () => {
+        const row = optionRow({ name: '', value: '' });
+        options.append(row);
+        row.querySelector('input')?.focus();
+    });
+
+    li.querySelector('.move-up')?.addEventListener('click', () => moveSection(li, -1));
+    li.querySelector('.move-down')?.addEventListener('click', () => moveSection(li, 1));
+    li.querySelector('.delete')?.addEventListener('click', () => deleteSection(li));
+
+    li.querySelector('form')?.addEventListener('submit', (e) => {
+        e.preventDefault();
+        saveSection(li);
+    });
+
+    return li;
+}
+
+/**
+ * @param {Option} option
+ */
+function optionRow(option) {
+    const template = document.getElementById('option-row');
+    if (template instanceof HTMLTemplateElement === false) throw new Error('missing option template');
+
+    const row = /** @type {HTMLLIElement} */ (template.content.firstElementChild?.cloneNode(true));
+    /** @type {HTMLInputElement} */ (row.querySelector('.option-name')).value = option.name;
+    // Values over several lines, such as long commands, keep their lines.
+    const value = /** @type {HTMLTextAreaElement} */ (row.querySelector('.option-value'));
+    value.value = option.value;
+    value.rows = option.value.split('\n').length;
+    row.querySelector('.remove-option')?.addEventListener('click', () => row.remove());
+
+    return row;
+}
+
+/**
+ * @param {HTMLLIElement} li
+ * @returns {KeySection}
+ */
+function readSection(li) {
+    const name = /** @type {HTMLInputElement} */ (li.querySelector('.section-name')).value.trim();
+    const options = [];
+
+    for (const row of li.querySelectorAll('.option')) {
+        const optionName = /** @type {HTMLInputElement} */ (row.querySelector('.option-name')).value.trim();
+        const value = /** @type {HTMLTextAreaElement} */ (row.querySelector('.option-value')).value;
+        if (optionName === '' && value === '') continue;
+        options.push({ name: optionName, value });
+    }
+
+    return { name, options };
+}
+
+/**
+ * Save a section, optionally moving it to a new position.
+ *
+ * @param {HTMLLIElement} li
+ * @param {number} [position]
+ * @returns {Promise<boolean>}
+ */
+async function saveSection(li, position) {
+    const section = readSection(li);
+    const saved = li.dataset.name;
+    if (position !== undefined) section.position = position;
+    else if (saved === undefined) section.position = sectionPosition(li);
+
+    // New sections go in the file being edited.
+    const file = document.getElementById('sections')?.dataset.file;
+    if (saved === undefined && file) section.file = file;
+
+    const url = `/keys/${encodeURIComponent(saved ?? section.name)}`;
+
+    showSectionErrors(li, []);
+    li.classList.remove('saved');
+
+    let response;
+    try {
+        response = await fetch(url, {
+            method: saved === undefined ? 'POST' : 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(section),
+        });
+    } catch {
+        showSectionErrors(li, [{ field: 'name', index: -1, message: 'Could not connect to server' }]);
+        return false;
+    }
+
+    if (response.status === 422) {
+        /** @type {{errors: FieldError[]}} */
+        const body = await response.json();
+        showSectionErrors(li, body.errors);
+        return false;
+    }
+
+    if (!response.ok) {
+        showSectionErrors(li, [{ field: 'name', index: -1, message: await response.text() }]);
+        return false;
+    }
+
+    /** @type {KeySection} */
+    const stored = await response.json();
+    li.dataset.name = stored.name;
+    li.classList.add('saved');
+    await refreshRaw();
+
+    return true;
+}
+
+/**
+ * Errors about an option are shown next to it, and everything else under the
+ * section name.
+ *
+ * @param {HTMLLIElement} li
+ * @param {FieldError[]} errors
+ */
+function showSectionErrors(li, errors) {
+    const rows = li.querySelectorAll('.option');
+    for (const el of li.querySelectorAll('.error')) el.textContent = '';
+
+    // Skipped empty rows don't count towards the indexes the server reports.
+    const filled = [...rows].filter((row) => {
+        const optionName = /** @type {HTMLInputElement} */ (row.querySelector('.option-name')).value.trim();
+        const value = /** @type {HTMLTextAreaElement} */ (row.querySelector('.option-value')).value;
+        return optionName !== '' || value !== '';
+    });
+
+    for (const error of errors) {
+        const row = error.index >= 0 ? filled[error.index] : undefined;
+        const target = row?.querySelector('.error') ?? li.querySelector('form > .error');
+        if (!target) continue;
+
+        target.textContent = target.textContent ? `${target.textContent} ${error.message}` : error.message;
+    }
+}
+
+/**
+ * @param {HTMLLIElement} li
+ * @param {number} direction -1 for up, 1 for down.
+ */
+async function moveSection(li, direction) {
+    const sibling = direction < 0 ? li.previousElementSibling : li.nextElementSibling;
+    if (sibling === null) return;
+
+    if (direction < 0) sibling.before(li);
+    else sibling.after(li);
+
+    if (li.dataset.name === undefined) return;
+
+    if (!await saveSection(li, sectionPosition(li))) {
+        if (direction < 0) sibling.after(li);
+        else sibling.before(li);
+    }
+}
+
+/**
+ * The position of a section in the config file, which doesn't have the
+ * sections that haven't been saved yet.
+ *
+ * @param {HTMLLIElement} li
+ */
+function sectionPosition(li) {
+    let position = 0;
+    for (let el = li.previousElementSibling; el !== null; el = el.previousElementSibling) {
+        if (el instanceof HTMLElement && el.dataset.name !== undefined) position++;
+    }
+
+    return position;
+}
+
+/**
+ * @param {HTMLLIElement} li
+ */
+async function deleteSection(li) {
+    const name = li.dataset.name;
+    if (name === undefined) {
+        li.remove();
+        return;
+    }
+
+    if (!window.confirm(`Delete ${name}?`)) return;
+
+    const response = await fetch(`/keys/${encodeURIComponent(name)}`, { method: 'DELETE' });
+    if (!response.ok) {
+        showSectionErrors(li, [{ field: 'name', index: -1, message: await response.text() }]);
+        return;
+    }
+
+    li.remove();
+    await refreshRaw();
+}
+
+/**
+ * Bring the text editor up to date after a section was changed. Unsaved
+ * text is left alone, and saving it shows what changed in the meantime.
+ */
+async function refreshRaw() {
+    const textarea = document.querySelector('#raw textarea');
+    const hash = document.querySelector('#raw input[name=hash]');
+    if (textarea instanceof HTMLTextAreaElement === false || hash instanceof HTMLInputElement === false) return;
+    if (textarea.value !== textarea.defaultValue) return;
+
+    const response = await fetch(window.location.href, { headers: { Accept: 'text/html' } });
+    if (!response.ok) return;
+
+    const page = new DOMParser().parseFromString(await response.text(), 'text/html');
+    const freshTextarea = page.querySelector('#raw textarea');
+    const freshHash = page.querySelector('#raw input[name=hash]');
+    if (freshTextarea instanceof HTMLTextAreaElement === false || freshHash instanceof HTMLInputElement === false) return;
+
+    textarea.defaultValue = freshTextarea.value;
+    textarea.value = freshTextarea.value;
+    hash.value = freshHash.value;
+}
+
+/**
+ * Select a line of the text editor, counting from one.
+ *
+ * @param {HTMLTextAreaElement} textarea
+ * @param {number} line
+ */
+function selectLine(textarea, line) {
+    const lines = textarea.value.split('\n');
+    const start = lines.slice(0, line - 1).reduce((offset, text) => offset + text.length + 1, 0);
+    const end = start + (lines[line - 1] || '').length;
+
+    textarea.focus();
+    textarea.setSelectionRange(start, end);
+
+    // Bring the line into view, assuming lines are all the same height.
+    textarea.scrollTop = (line - 1) / lines.length * textarea.scrollHeight - textarea.clientHeight / 2;
+}
+
+/**
+ * @param {boolean} enabled
+ */
+function showSound(enabled) {
+    const el = document.getElementById('config-sound');
+    if (!el) return;
+
+    el.classList.toggle('on', enabled);
+    el.classList.toggle('off', !enabled);
+
+    const labelEl = el.querySelector('.label');
+    if (labelEl) labelEl.textContent = enabled ? 'on' : 'off';
+}
+
 /**
  * @param {string} message
  * @param {string} type
@@ -144,10 +554,103 @@ function setStatus(message, type) {
     container.querySelector('.icon use')?.setAttribute('xlink:href', `#icon-${icon}`);
 }
 
+/**
+ * @typedef {{name: string, type: string, choices?: string[], default?: string, min?: number, max?: number}} ParamSpec
+ */
+
+/**
+ * Confirm and ask for params as the key requires, then run it.
+ *
+ * @param {HTMLAnchorElement} el
+ */
+async function triggerKey(el) {
+    const confirmed = el.dataset.requireConfirm !== undefined;
+    if (confirmed && !window.confirm(`Run ${el.querySelector('.key-label')?.textContent}?`)) return;
+
+    const values = el.dataset.params ? await askParams(el) : {};
+    if (values === null) return;
+
+    window.dispatchEvent(new CustomEvent('app:start', { detail: { node: el } }));
+
+    // Give the start message some time to display and not flicker.
+    setTimeout(() => runTrigger(el, confirmed, values), 500);
+}
+
+/**
+ * Show a dialog for the params the key declares.
+ *
+ * @param {HTMLAnchorElement} el
+ * @returns {Promise<Record<string, string> | null>} The values, or null if cancelled.
+ */
+function askParams(el) {
+    const dialog = document.getElementById('param-dialog');
+    const form = dialog?.querySelector('form');
+    if (!(dialog instanceof HTMLDialogElement) || !(form instanceof HTMLFormElement)) return Promise.resolve({});
+
+    /** @type {ParamSpec[]} */
+    const specs = JSON.parse(el.dataset.params || '[]');
+
+    const title = dialog.querySelector('.title');
+    if (title) title.textContent = el.querySelector('.key-label')?.textContent || '';
+    dialog.querySelector('.fields')?.replaceChildren(...specs.map(paramField));
+
+    return new Promise((resolve) => {
+        dialog.addEventListener('close', () => {
+            if (dialog.returnValue !== 'run') {
+                resolve(null);
+                return;
+            }
+
+            /** @type {Record<string, string>} */
+            const values = {};
+            new FormData(form).forEach((value, name) => {
+                values[name] = String(value);
+            });
+            resolve(values);
+        }, { once: true });
+
+        dialog.returnValue = '';
+        dialog.showModal();
+    });
+}
+
+/**
+ * @param {ParamSpec} spec
+ */
+function paramField(spec) {
+    const label = document.createElement('label');
+    label.textContent = spec.name;
+
+    /** @type {HTMLInputElement | HTMLSelectElement} */
+    let input;
+    if (spec.type === 'choice') {
+        input = document.createElement('select');
+        for (const choice of spec.choices || []) {
+            const selected = choice === spec.default;
+            input.append(new Option(choice, choice, selected, selected));
+        }
+    } else {
+        input = document.createElement('input');
+        input.type = (spec.type === 'text') ? 'text' : 'number';
+        input.step = (spec.type === 'number') ? 'any' : '1';
+        if (spec.min !== undefined) input.min = String(spec.min);
+        if (spec.max !== undefined) input.max = String(spec.max);
+        input.value = spec.default || '';
+    }
+
+    input.name = spec.name;
+    input.required = !spec.default;
+    label.append(input);
+
+    return label;
+}
+
 /**
  * @param {HTMLAnchorElement} el
+ * @param {boolean} confirmed
+ * @param {Record<string, string>} values Params of the command.
  */
-async function runTrigger(el) {
+async function runTrigger(el, confirmed, values) {
     let eventName = 'app:fail';
     let result = 'Could not connect to server';
     let status = 0;
@@ -155,23 +658,30 @@ async function runTrigger(el) {
     let locked = false;
 
     try {
-        const response = await fetch(el.href, { method: 'POST' });
-        if (response.ok) {
-            eventName = 'app:success';
-            state = response.headers.get("X-Keys-State") || "";
-            locked = Boolean(Number.parseInt(response.headers.get("X-Keys-Locked") || "", 10) || 0);
-        }
+        const params = new URLSearchParams({ source: 'browser', async: '1' });
+        if (confirmed) params.set('confirm', '1');
 
-        if (response.headers.get("Content-Type") === "text/html") {
-            const parser = new DOMParser()
-            const doc = parser.parseFromString(await response.text(), "text/html")
-            const body = doc.querySelector('body');
-            result = (body) ? body.innerHTML : '<em>Response cannot be shown.</em>';
+        const response = await fetch(`${el.href}?${params}`, { method: 'POST', body: new URLSearchParams(values) });
+        status = response.status;
+
+        if (response.status === 202) {
+            const job = await waitForJob(response.headers.get('Location') || '');
+            status = jobStatusCodes[job.status] || 500;
+            state = job.state || '';
+            result = (status === 200) ? renderOutput(job.output, job.content_type) : renderOutput(job.error || '', 'text/plain');
+            if (status === 200) eventName = 'app:success';
         } else {
-            result = await response.text();
-        }
+            if (response.ok) {
+                eventName = 'app:success';
+                state = response.headers.get("X-Keys-State") || "";
+                locked = Boolean(Number.parseInt(response.headers.get("X-Keys-Locked") || "", 10) || 0);
 
-        status = response.status;
+                const soundHeader = response.headers.get("X-Keys-Sound");
+                if (soundHeader !== null) showSound(soundHeader === "1");
+            }
+
+            result = renderOutput(await response.text(), response.headers.get("Content-Type"));
+        }
     } finally {
         const event = new CustomEvent(eventName, {
             detail: { node: el, status, result, locked }
@@ -191,3 +701,116 @@ async function runTrigger(el) {
         }
     }
 }
+
+/**
+ * @param {HTMLAnchorElement} el
+ */
+async function refreshState(el) {
+    try {
+        const response = await fetch(el.href.replace('/trigger/', '/state/'));
+        if (!response.ok) return;
+
+        const stateEl = el.querySelector('.state');
+        if (stateEl) {
+            stateEl.textContent = response.headers.get("X-Keys-State") || "";
+        }
+    } catch {
+        // The next interval will try again.
+    }
+}
+
+/**
+ * @param {string} name
+ */
+async function cancelRunning(name) {
+    const response = await fetch('/running', { headers: { Accept: 'application/json' } });
+    if (!response.ok) return;
+
+    /** @type {{id: string, key: string}[]} */
+    const jobs = await response.json();
+
+    for (const job of jobs.filter((j) => j.key === name)) {
+        await fetch(`/running/${job.id}`, { method: 'DELETE' });
+    }
+}
+
+/**
+ * Turn command output into markup for the status area.
+ *
+ * @param {string} text
+ * @param {string|null|undefined} contentType
+ */
+function renderOutput(text, contentType) {
+    if (contentType !== "text/html") {
+        const el = document.createElement('span');
+        el.className = 'plain';
+        el.textContent = text;
+        return el.outerHTML;
+    }
+
+    const parser = new DOMParser()
+    const doc = parser.parseFromString(text, "text/html")
+    const body = doc.querySelector('body');
+    if (!body) return '<em>Response cannot be shown.</em>';
+
+    sanitize(body);
+    return body.innerHTML;
+}
+
+const allowedTags = new Set([
+    'A', 'B', 'BLOCKQUOTE', 'BR', 'CODE', 'DD', 'DEL', 'DIV', 'DL', 'DT', 'EM',
+    'H1', 'H2', 'H3', 'H4', 'H5', 'H6', 'HR', 'I', 'INS', 'KBD', 'LI', 'MARK',
+    'OL', 'P', 'PRE', 'S', 'SMALL', 'SPAN', 'STRONG', 'SUB', 'SUP', 'TABLE',
+    'TBODY', 'TD', 'TFOOT', 'TH', 'THEAD', 'TR', 'U', 'UL',
+]);
+
+const droppedTags = new Set(['SCRIPT', 'STYLE', 'IFRAME', 'OBJECT', 'EMBED', 'TEMPLATE', 'NOSCRIPT']);
+
+const allowedAttributes = new Set(['class', 'href', 'title', 'colspan', 'rowspan']);
+
+/**
+ * Remove anything from command output that could run code or escape the status area.
+ * Unknown elements are replaced by their content.
+ *
+ * @param {HTMLElement} root
+ */
+function sanitize(root) {
+    for (const el of Array.from(root.querySelectorAll('*'))) {
+        if (droppedTags.has(el.tagName)) {
+            el.remove();
+            continue;
+        }
+
+        if (!allowedTags.has(el.tagName)) {
+            el.replaceWith(...Array.from(el.childNodes));
+            continue;
+        }
+
+        for (const attr of Array.from(el.attributes)) {
+            if (!allowedAttributes.has(attr.name)) {
+                el.removeAttribute(attr.name);
+            }
+        }
+
+        const href = el.getAttribute('href');
+        if (href !== null && !/^(https?:|mailto:|\/|#)/i.test(href.trim())) {
+            el.removeAttribute('href');
+        }
+    }
+}
+
+/**
+ * @param {string} url
+ * @returns {Promise<{status: string, output: string, content_type?: string, error?: string, state?: string}>}
+ */
+async function waitForJob(url) {
+    for (;;) {
+        await new Promise((resolve) => setTimeout(resolve, 500));
+
+        const response = await fetch(url, { headers: { Accept: 'application/json' } });
+        if (!response.ok) throw new Error(`Job status unavailable: ${response.status}`);
+
+        const job = await response.json();
+        if (job.status !== 'running') return job;
+    }
+}
@@ -1,15 +1,46 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"keys/internal/job"
 	"keys/internal/keymap"
+	"keys/internal/notify"
+	"keys/internal/sound"
+	"keys/internal/throttle"
+	"log"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 )
 
+// TokenHeader carries the trigger token.
+const TokenHeader = "X-Keys-Token"
+
 type Config struct {
-	KeyboardFound  bool
-	KeyboardLocked bool
-	Keymap         *keymap.Keymap
-	PublicUrl      string
+	KeyboardFound bool
+	Keymap        *keymap.Keymap
+	Limiter       *throttle.Limiter
+	Jobs          *job.Registry
+	Notifier      *notify.Notifier
+
+	// TriggerUrl is the loopback address of the server, which the keyboard
+	// listener and the scheduler send their requests to. Unlike the public
+	// URL it never comes from request headers, so the trigger token doesn't
+	// leave the machine.
+	TriggerUrl string
+
+	// TriggerToken is sent by the keyboard listener and the scheduler with
+	// their requests, so that clients can't claim to be them.
+	TriggerToken string
+
+	// publicUrl is where clients reach the server. Requests forwarded by a
+	// proxy change it.
+	publicUrl atomic.Value
+
+	// keyboardLocked is read by the keyboard listener and the scheduler
+	// while requests change it.
+	keyboardLocked atomic.Bool
 }
 
 func NewConfig(configFile string) (*Config, error) {
@@ -24,8 +55,95 @@ func NewConfig(configFile string) (*Config, error) {
 	}
 
 	cfg := Config{
-		Keymap: keymap,
+		Keymap:   keymap,
+		Limiter:  throttle.NewLimiter(),
+		Jobs:     job.NewRegistry(),
+		Notifier: notify.NewNotifier(os.Getenv("DBUS_SESSION_BUS_ADDRESS")),
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
 	}
+	cfg.TriggerToken = hex.EncodeToString(token)
 
 	return &cfg, nil
 }
+
+func (c *Config) PublicUrl() string {
+	url, _ := c.publicUrl.Load().(string)
+	return url
+}
+
+func (c *Config) SetPublicUrl(url string) {
+	c.publicUrl.Store(url)
+}
+
+func (c *Config) KeyboardLocked() bool {
+	return c.keyboardLocked.Load()
+}
+
+func (c *Config) SetKeyboardLocked(locked bool) {
+	c.keyboardLocked.Store(locked)
+}
+
+// ConfigureSound applies the sound and speech options of the keymap. It is
+// kept out of loading the keymap so that commands such as check don't set
+// up audio.
+func (c *Config) ConfigureSound() {
+	km := c.Keymap
+
+	volumes := make(map[sound.Name]int)
+	for option, name := range map[string]sound.Name{
+		"sound_success_volume": sound.Confirmation,
+		"sound_error_volume":   sound.Error,
+		"sound_lock_volume":    sound.Lock,
+		"sound_unlock_volume":  sound.Unlock,
+		"sound_prompt_volume":  sound.Prompt,
+	} {
+		if km.Option(option).String() != "" {
+			volumes[name] = km.Volume(option)
+		}
+	}
+
+	sound.Configure(sound.Settings{
+		Dir: filepath.Dir(km.Filename),
+		Files: map[sound.Name]string{
+			sound.Confirmation: km.Option("sound_success").String(),
+			sound.Error:        km.Option("sound_error").String(),
+			sound.Lock:         km.Option("sound_lock").String(),
+			sound.Unlock:       km.Option("sound_unlock").String(),
+		},
+		Volume:  km.Volume("sound_volume"),
+		Volumes: volumes,
+		Device:  km.Option("sound_device").String(),
+		Muted:   !km.SoundAllowed,
+	})
+
+	configureSpeech(km)
+}
+
+// Speech is only set up when some key can use it, so that a missing engine
+// isn't reported to people who never asked for speech.
+func configureSpeech(km *keymap.Keymap) {
+	wanted := km.Speech != "off"
+	for key := range km.Keys() {
+		if key.Speech != "" && key.Speech != "off" {
+			wanted = true
+			break
+		}
+	}
+
+	if !wanted {
+		sound.ConfigureSpeech(nil, 0)
+		return
+	}
+
+	engine := km.Option("speech_engine").In("espeak-ng", keymap.SpeechEngines)
+	synthesizer, err := sound.NewSynthesizer(engine, km.Option("speech_voice").String())
+	if err != nil {
+		log.Println(err)
+	}
+
+	sound.ConfigureSpeech(synthesizer, km.Volume("speech_volume"))
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"keys/internal/sound"
 	"os"
 	"path/filepath"
 	"testing"
@@ -27,3 +28,36 @@ func TestConfigFileValidity(t *testing.T) {
 		t.Error("malformed config file was not rejected")
 	}
 }
+
+func TestConfigureSound(t *testing.T) {
+	t.Cleanup(func() { sound.Configure(sound.Settings{Volume: 100}) })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.ini")
+	if err := os.WriteFile(path, []byte("sound_volume = 40\n\n[test]\ncommand = echo test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if volume := sound.CurrentStatus().Volume; volume != 100 {
+		t.Fatalf("expected loading the config to leave sound alone, got volume %d", volume)
+	}
+
+	cfg.ConfigureSound()
+	if volume := sound.CurrentStatus().Volume; volume != 40 {
+		t.Fatalf("expected volume 40, got %d", volume)
+	}
+
+	cfg.Keymap.OnLoad = cfg.ConfigureSound
+	if err := cfg.Keymap.WriteRaw([]byte("sound_volume = 60\nsound = off\n\n[test]\ncommand = echo test\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := sound.CurrentStatus(); status.Volume != 60 || !status.Muted {
+		t.Errorf("expected a reload to apply the new settings, got %+v", status)
+	}
+}
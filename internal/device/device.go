@@ -92,7 +92,7 @@ func worker(deviceEvents <-chan *DeviceEvent, cfg *config.Config, callback func(
 
 		codeName := evdev.CodeName(deviceEvent.Event.Type, deviceEvent.Event.Code)
 
-		if cfg.KeyboardLocked {
+		if cfg.KeyboardLocked() {
 			log.Printf("Ignoring keypress of %s because the keyboard is locked", codeName)
 			continue
 		}
@@ -114,13 +114,14 @@ func worker(deviceEvents <-chan *DeviceEvent, cfg *config.Config, callback func(
 
 func trigger(keyBuffer []string, cfg *config.Config) {
 	key := strings.Join(keyBuffer, ",")
-	url := fmt.Sprintf("%s/trigger/%s", cfg.PublicUrl, url.PathEscape(key))
+	url := fmt.Sprintf("%s/trigger/%s?source=keyboard", cfg.TriggerUrl, url.PathEscape(key))
 
 	req, err := http.NewRequest(http.MethodPost, url, nil)
 	if err != nil {
 		log.Fatalf("Error creating POST request: %s", err)
 		return
 	}
+	req.Header.Set(config.TokenHeader, cfg.TriggerToken)
 
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -0,0 +1,210 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// String is used as a CSS class when diffs are shown in the browser.
+func (op Op) String() string {
+	switch op {
+	case Delete:
+		return "delete"
+	case Insert:
+		return "insert"
+	default:
+		return "equal"
+	}
+}
+
+func (op Op) Prefix() string {
+	switch op {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// Line is a line of either version. Old and New are its line numbers,
+// counting from one, or zero in the version it isn't part of.
+type Line struct {
+	Op   Op
+	Text string
+	Old  int
+	New  int
+}
+
+// Hunk is a run of changes with the unchanged lines around them.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+func split(text []byte) []string {
+	s := strings.ReplaceAll(string(text), "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, "\n")
+}
+
+// Lines compares two texts line by line. It finds a longest common
+// subsequence, which is quadratic but fine for files the size of a config.
+func Lines(a, b []byte) []Line {
+	before, after := split(a), split(b)
+
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix && before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	middleBefore := before[prefix : len(before)-suffix]
+	middleAfter := after[prefix : len(after)-suffix]
+
+	// common[i][j] is the length of the longest common subsequence of
+	// middleBefore[i:] and middleAfter[j:].
+	common := make([][]int, len(middleBefore)+1)
+	for i := range common {
+		common[i] = make([]int, len(middleAfter)+1)
+	}
+	for i := len(middleBefore) - 1; i >= 0; i-- {
+		for j := len(middleAfter) - 1; j >= 0; j-- {
+			if middleBefore[i] == middleAfter[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]Line, 0, len(before)+len(after))
+	for i := range prefix {
+		lines = append(lines, Line{Op: Equal, Text: before[i], Old: i + 1, New: i + 1})
+	}
+
+	i, j := 0, 0
+	for i < len(middleBefore) || j < len(middleAfter) {
+		switch {
+		case i < len(middleBefore) && j < len(middleAfter) && middleBefore[i] == middleAfter[j]:
+			lines = append(lines, Line{Op: Equal, Text: middleBefore[i], Old: prefix + i + 1, New: prefix + j + 1})
+			i++
+			j++
+		case j == len(middleAfter) || (i < len(middleBefore) && common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, Line{Op: Delete, Text: middleBefore[i], Old: prefix + i + 1})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: middleAfter[j], New: prefix + j + 1})
+			j++
+		}
+	}
+
+	for k := range suffix {
+		lines = append(lines, Line{Op: Equal, Text: before[len(before)-suffix+k], Old: len(before) - suffix + k + 1, New: len(after) - suffix + k + 1})
+	}
+
+	return lines
+}
+
+// Hunks groups the changes between two texts, keeping up to context
+// unchanged lines around each. It is empty when the texts are the same.
+func Hunks(a, b []byte, context int) []Hunk {
+	lines := Lines(a, b)
+
+	var hunks []Hunk
+	lastChange := -1
+
+	trailing := func() []Line {
+		return lines[lastChange+1 : min(len(lines), lastChange+1+context)]
+	}
+
+	for index, line := range lines {
+		if line.Op == Equal {
+			continue
+		}
+
+		start := max(0, index-context)
+		if len(hunks) > 0 && start <= lastChange+context+1 {
+			start = lastChange + 1
+		} else {
+			if len(hunks) > 0 {
+				hunks[len(hunks)-1].Lines = append(hunks[len(hunks)-1].Lines, trailing()...)
+			}
+			hunks = append(hunks, Hunk{})
+		}
+
+		hunks[len(hunks)-1].Lines = append(hunks[len(hunks)-1].Lines, lines[start:index+1]...)
+		lastChange = index
+	}
+
+	if len(hunks) > 0 {
+		hunks[len(hunks)-1].Lines = append(hunks[len(hunks)-1].Lines, trailing()...)
+	}
+
+	for i := range hunks {
+		hunks[i].count()
+	}
+
+	return hunks
+}
+
+func (h *Hunk) count() {
+	for _, line := range h.Lines {
+		if line.Op != Insert {
+			if h.OldLines == 0 {
+				h.OldStart = line.Old
+			}
+			h.OldLines++
+		}
+		if line.Op != Delete {
+			if h.NewLines == 0 {
+				h.NewStart = line.New
+			}
+			h.NewLines++
+		}
+	}
+}
+
+// Unified formats the changes between two texts like diff -u.
+func Unified(oldName, newName string, a, b []byte) string {
+	hunks := Hunks(a, b, 3)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, h := range hunks {
+		out.WriteString(h.Header() + "\n")
+		for _, line := range h.Lines {
+			out.WriteString(line.Op.Prefix() + line.Text + "\n")
+		}
+	}
+
+	return out.String()
+}
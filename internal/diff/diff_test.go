@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	a := []byte("one\ntwo\nthree\nfour\n")
+	b := []byte("one\n2\nthree\nfour\nfive\n")
+
+	var ops []string
+	for _, line := range Lines(a, b) {
+		ops = append(ops, line.Op.Prefix()+line.Text)
+	}
+
+	want := " one -two +2  three  four +five"
+	if got := strings.Join(ops, " "); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var a, b strings.Builder
+	for i := range 20 {
+		line := strings.Repeat("x", i+1) + "\n"
+		a.WriteString(line)
+		if i != 2 && i != 15 {
+			b.WriteString(line)
+		}
+	}
+
+	hunks := Hunks([]byte(a.String()), []byte(b.String()), 3)
+	if len(hunks) != 2 {
+		t.Fatalf("expected 2 hunks, got %d", len(hunks))
+	}
+
+	if header := hunks[0].Header(); header != "@@ -1,6 +1,5 @@" {
+		t.Errorf("unexpected first header %s", header)
+	}
+
+	if header := hunks[1].Header(); header != "@@ -13,7 +12,6 @@" {
+		t.Errorf("unexpected second header %s", header)
+	}
+
+	if len(Hunks([]byte("same\n"), []byte("same\n"), 3)) != 0 {
+		t.Error("expected no hunks for identical text")
+	}
+}
+
+func TestUnified(t *testing.T) {
+	got := Unified("a", "b", []byte("keep\nold\n"), []byte("keep\nnew\n"))
+	want := "--- a\n+++ b\n@@ -1,2 +1,2 @@\n keep\n-old\n+new\n"
+
+	if got != want {
+		t.Fatalf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	if Unified("a", "b", nil, []byte("added\n")) != "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+added\n" {
+		t.Fatal("unexpected diff from an empty file")
+	}
+}
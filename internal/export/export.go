@@ -0,0 +1,292 @@
+// Package export draws the keymap as a cheat sheet to print and cut out,
+// with a card the size of a key for each one.
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"keys/internal/keymap"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownFormat = errors.New("export format is not known")
+	ErrUnknownGrid   = errors.New("grid is not known")
+	ErrGridTooWide   = errors.New("grid is too wide to print at the size of the keys")
+)
+
+// Formats are the kinds of file a cheat sheet can be written as.
+var Formats = []string{"pdf", "svg", "html-print"}
+
+// DefaultGrid is used when the keymap doesn't have a layout.
+const DefaultGrid = "4x4"
+
+// FindGrid picks the layout that cards are placed on: a built-in layout by
+// name, or the keymap's own layout if name is empty. Layouts wider than a
+// page, such as a full keyboard, can't be printed.
+func FindGrid(km *keymap.Keymap, name string) (keymap.Layout, error) {
+	grid := km.Layout()
+	if name == "" && len(grid.Keys) == 0 {
+		name = DefaultGrid
+	}
+
+	if name != "" {
+		var found bool
+		if grid, found = keymap.BuiltinLayout(name); !found {
+			return keymap.Layout{}, fmt.Errorf("%s: %w, use one of %s", name, ErrUnknownGrid, strings.Join(keymap.LayoutNames, ", "))
+		}
+	}
+
+	if width, _ := sheetSize(grid); width > pageWidth-2*pageMargin {
+		return keymap.Layout{}, fmt.Errorf("%s: %w", grid.Name, ErrGridTooWide)
+	}
+
+	return grid, nil
+}
+
+// Card is what is printed for a key.
+type Card struct {
+	Name        string
+	PhysicalKey string
+	States      []string
+}
+
+// Sheet is the cards of one row of the keymap, one for each key of the
+// grid. Cards with no name are left blank. Rows with more keys than the grid
+// has take several sheets.
+type Sheet struct {
+	Row   string
+	Cards []Card
+}
+
+// Sheets groups the keys of the keymap by row, in the order they are
+// written. A card goes where its physical key is on the grid, and the keys
+// that aren't on it fill the places left in order.
+func Sheets(km *keymap.Keymap, grid keymap.Layout) []Sheet {
+	var sheets []Sheet
+	var row string
+	var cards []Card
+
+	for key := range km.Keys() {
+		if key.Row != row && len(cards) > 0 {
+			sheets = append(sheets, placeCards(row, cards, grid)...)
+			cards = nil
+		}
+
+		row = key.Row
+		cards = append(cards, Card{
+			Name:        key.Name,
+			PhysicalKey: key.PhysicalKey,
+			States:      key.States,
+		})
+	}
+
+	if len(cards) > 0 {
+		sheets = append(sheets, placeCards(row, cards, grid)...)
+	}
+
+	return sheets
+}
+
+func placeCards(row string, cards []Card, grid keymap.Layout) []Sheet {
+	sheet := Sheet{Row: row, Cards: make([]Card, len(grid.Keys))}
+
+	var rest []Card
+	for _, card := range cards {
+		i := slices.IndexFunc(grid.Keys, func(k keymap.LayoutKey) bool { return k.PhysicalKey == card.PhysicalKey })
+		if i == -1 || sheet.Cards[i].Name != "" {
+			rest = append(rest, card)
+			continue
+		}
+
+		sheet.Cards[i] = card
+	}
+
+	sheets := []Sheet{sheet}
+	for _, card := range rest {
+		last := &sheets[len(sheets)-1]
+		i := slices.IndexFunc(last.Cards, func(c Card) bool { return c.Name == "" })
+		if i == -1 {
+			sheets = append(sheets, Sheet{Row: row, Cards: make([]Card, len(grid.Keys))})
+			last, i = &sheets[len(sheets)-1], 0
+		}
+
+		last.Cards[i] = card
+	}
+
+	return sheets
+}
+
+func ContentType(format string) string {
+	switch format {
+	case "pdf":
+		return "application/pdf"
+	case "svg":
+		return "image/svg+xml"
+	default:
+		return "text/html; charset=utf-8"
+	}
+}
+
+// Extension is the file extension of a format, for naming the file.
+func Extension(format string) string {
+	if format == "html-print" {
+		return ".html"
+	}
+
+	return "." + format
+}
+
+// FormatFor picks the format of a file from its extension, such as pdf for
+// keys.pdf.
+func FormatFor(filename string) (string, error) {
+	for _, format := range Formats {
+		if strings.HasSuffix(strings.ToLower(filename), Extension(format)) {
+			return format, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: %w, use .pdf, .svg or .html", filename, ErrUnknownFormat)
+}
+
+// Write draws the sheets in one of the Formats.
+func Write(w io.Writer, format string, sheets []Sheet, grid keymap.Layout) error {
+	switch format {
+	case "pdf":
+		return writePDF(w, sheets, grid)
+	case "svg":
+		return writeSVG(w, sheets, grid)
+	case "html-print":
+		return writeHTML(w, sheets, grid)
+	default:
+		return fmt.Errorf("%s: %w, use one of %s", format, ErrUnknownFormat, strings.Join(Formats, ", "))
+	}
+}
+
+// Sizes are in points. A key is 19.05mm from one to the next, and a card
+// leaves a gap to cut along.
+const (
+	pitch       = 54.0
+	gap         = 4.0
+	padding     = 3.0
+	titleHeight = 16.0
+	sheetGap    = 18.0
+	pageWidth   = 595.0
+	pageHeight  = 842.0
+	pageMargin  = 36.0
+)
+
+type font int
+
+const (
+	regular font = iota
+	bold
+	mono
+)
+
+// canvas is what a sheet is drawn on, with the origin at the top left.
+type canvas interface {
+	rect(x, y, width, height float64)
+	text(x, y, size float64, f font, s string)
+}
+
+// sheetSize is the width and height of a sheet with its title.
+func sheetSize(grid keymap.Layout) (float64, float64) {
+	width, height := grid.Size()
+	return width * pitch, titleHeight + height*pitch
+}
+
+func drawSheet(c canvas, x, y float64, sheet Sheet, grid keymap.Layout) {
+	if sheet.Row != "" {
+		width, _ := sheetSize(grid)
+		c.text(x, y+10, 10, bold, fit(sheet.Row, width, 10, bold))
+	}
+	y += titleHeight
+
+	for i, card := range sheet.Cards {
+		if card.Name == "" {
+			continue
+		}
+
+		k := grid.Keys[i]
+		drawCard(c, x+k.X*pitch, y+k.Y*pitch, k.Width*pitch-gap, k.Height*pitch-gap, card)
+	}
+}
+
+func drawCard(c canvas, x, y, width, height float64, card Card) {
+	c.rect(x, y, width, height)
+
+	inner := width - 2*padding
+	c.text(x+padding, y+padding+7, 7, bold, fit(card.Name, inner, 7, bold))
+	c.text(x+padding, y+padding+15, 6, mono, fit(card.PhysicalKey, inner, 6, mono))
+
+	// States go at the bottom, one per line as far as they fit.
+	lines := max(1, int((height-2*padding-18)/6))
+	states := card.States
+	if len(states) > lines {
+		states = append(slices.Clip(states[:lines-1]), fmt.Sprintf("+%d more", len(states)-lines+1))
+	}
+
+	for i, state := range states {
+		c.text(x+padding, y+height-padding-float64(len(states)-1-i)*6, 5, regular, fit(state, inner, 5, regular))
+	}
+}
+
+// fit shortens text to fit a width, going by the widest that characters of
+// the font usually are since the exact widths aren't known.
+func fit(s string, width, size float64, f font) string {
+	average := 0.58
+	if f == mono {
+		average = 0.6
+	}
+
+	limit := int(width / (size * average))
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+
+	return string(runes[:max(0, limit-1)]) + "…"
+}
+
+// layout places sheets left to right and top to bottom within a width,
+// starting a new page when one is full. A height of 0 means a single page
+// as tall as needed.
+func layout(sheets []Sheet, grid keymap.Layout, width, height float64) [][]placement {
+	sheetWidth, sheetHeight := sheetSize(grid)
+	perLine := max(1, int((width+sheetGap)/(sheetWidth+sheetGap)))
+
+	lines := max(1, int((height+sheetGap)/(sheetHeight+sheetGap)))
+	if height == 0 {
+		lines = (len(sheets) + perLine - 1) / perLine
+	}
+	perPage := max(1, perLine*lines)
+
+	var pages [][]placement
+	for i, sheet := range sheets {
+		if i%perPage == 0 {
+			pages = append(pages, nil)
+		}
+
+		n := i % perPage
+		pages[len(pages)-1] = append(pages[len(pages)-1], placement{
+			sheet: sheet,
+			x:     float64(n%perLine) * (sheetWidth + sheetGap),
+			y:     float64(n/perLine) * (sheetHeight + sheetGap),
+		})
+	}
+
+	// An empty keymap still gets a page.
+	if len(pages) == 0 {
+		pages = append(pages, nil)
+	}
+
+	return pages
+}
+
+type placement struct {
+	sheet Sheet
+	x, y  float64
+}
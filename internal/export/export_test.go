@@ -0,0 +1,231 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"keys/internal/keymap"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const exportFixture = `[one]
+physical_key = a
+command = echo one
+
+[--Media]
+
+[play]
+physical_key = kp5
+state = playing
+state = paused
+command = play
+command = pause
+
+[next]
+physical_key = kp6
+command = next
+
+[(a) <b>&c]
+physical_key = kp7
+command = loud
+`
+
+func keymapFixture(t *testing.T) *keymap.Keymap {
+	t.Helper()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept beside the package like the other tests' configs.
+	filename := filepath.Join(cwd, "keys-test-export.ini")
+	t.Cleanup(func() {
+		if err := os.Remove(filename); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := os.WriteFile(filename, []byte(exportFixture), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := keymap.NewKeymap(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return km
+}
+
+func TestSheets(t *testing.T) {
+	km := keymapFixture(t)
+	grid, err := keymap.ParseLayout([]string{"a kp6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, sheet := range Sheets(km, grid) {
+		var names []string
+		for _, card := range sheet.Cards {
+			names = append(names, card.Name)
+		}
+		got = append(got, sheet.Row+": "+strings.Join(names, ", "))
+	}
+
+	// next is on its physical key, and the others fill the places left.
+	want := []string{": one, ", "Media: play, next", "Media: (a) <b>&c, "}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if states := Sheets(km, grid)[1].Cards[0].States; len(states) != 2 || states[1] != "paused" {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func TestFindGrid(t *testing.T) {
+	km := keymapFixture(t)
+
+	for name, size := range map[string][2]float64{"": {4, 4}, "3x3": {3, 3}, "4x4": {4, 4}, "numpad": {4, 5}} {
+		grid, err := FindGrid(km, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if width, height := grid.Size(); width != size[0] || height != size[1] {
+			t.Errorf("expected %q to be %vx%v keys, got %vx%v", name, size[0], size[1], width, height)
+		}
+	}
+
+	if _, err := FindGrid(km, "5x5"); !errors.Is(err, ErrUnknownGrid) {
+		t.Fatalf("expected ErrUnknownGrid, got %v", err)
+	}
+
+	if _, err := FindGrid(km, "full"); !errors.Is(err, ErrGridTooWide) {
+		t.Fatalf("expected ErrGridTooWide, got %v", err)
+	}
+
+	if err := km.SetLayout("numpad"); err != nil {
+		t.Fatal(err)
+	}
+
+	if grid, err := FindGrid(km, ""); err != nil || grid.Name != "numpad" {
+		t.Fatalf("expected the layout of the keymap, got %q: %v", grid.Name, err)
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	for filename, want := range map[string]string{"keys.pdf": "pdf", "Keys.SVG": "svg", "keys.html": "html-print"} {
+		if got, err := FormatFor(filename); err != nil || got != want {
+			t.Errorf("expected %s for %s, got %s: %v", want, filename, got, err)
+		}
+	}
+
+	if _, err := FormatFor("keys.png"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestFit(t *testing.T) {
+	if got := fit("short", 44, 7, bold); got != "short" {
+		t.Errorf("expected short text to be left alone, got %q", got)
+	}
+
+	if got := fit("a very long key name", 44, 7, bold); got != "a very lo…" {
+		t.Errorf("unexpected %q", got)
+	}
+}
+
+func TestWriteSVG(t *testing.T) {
+	km := keymapFixture(t)
+	grid, _ := FindGrid(km, "3x3")
+
+	var out bytes.Buffer
+	if err := Write(&out, "svg", Sheets(km, grid), grid); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := out.String()
+	for _, want := range []string{"<svg ", ">Media</text>", ">(a) &lt;b&gt;&amp;c</text>", ">kp5</text>", ">paused</text>"} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("expected %q in:\n%s", want, svg)
+		}
+	}
+
+	if count := strings.Count(svg, "<rect "); count != 4 {
+		t.Errorf("expected a card for each of 4 keys, got %d", count)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	km := keymapFixture(t)
+	grid, _ := FindGrid(km, "4x4")
+
+	var out bytes.Buffer
+	if err := Write(&out, "html-print", Sheets(km, grid), grid); err != nil {
+		t.Fatal(err)
+	}
+
+	if count := strings.Count(out.String(), "<svg "); count != 2 {
+		t.Errorf("expected an image for each of 2 rows, got %d", count)
+	}
+
+	if !strings.Contains(out.String(), "<style>"+printStyle+"</style>") {
+		t.Error("expected the style that the content security policy allows")
+	}
+}
+
+// TestWritePDF checks the structure a reader relies on: that the cross
+// reference table points at each object and each stream is as long as it
+// says.
+func TestWritePDF(t *testing.T) {
+	km := keymapFixture(t)
+	grid, _ := FindGrid(km, "numpad")
+
+	var out bytes.Buffer
+	if err := Write(&out, "pdf", Sheets(km, grid), grid); err != nil {
+		t.Fatal(err)
+	}
+	pdf := out.Bytes()
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(pdf)
+	if match == nil {
+		t.Fatal("expected startxref at the end")
+	}
+
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatal("startxref doesn't point at the cross reference table")
+	}
+
+	for i, offset := range regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf, -1) {
+		n, _ := strconv.Atoi(string(offset[1]))
+		if !bytes.HasPrefix(pdf[n:], fmt.Appendf(nil, "%d 0 obj\n", i+1)) {
+			t.Errorf("object %d is not at %d", i+1, n)
+		}
+	}
+
+	for _, stream := range regexp.MustCompile(`/Length (\d+) >>\nstream\n`).FindAllSubmatchIndex(pdf, -1) {
+		length, _ := strconv.Atoi(string(pdf[stream[2]:stream[3]]))
+		if !bytes.HasPrefix(pdf[stream[1]+length:], []byte("\nendstream")) {
+			t.Errorf("stream at %d is not %d bytes long", stream[1], length)
+		}
+	}
+
+	if !bytes.Contains(pdf, []byte(`(\(a\) <b>&c) Tj`)) {
+		t.Errorf("expected parentheses to be escaped in:\n%s", pdf)
+	}
+}
+
+func TestPDFString(t *testing.T) {
+	if got := pdfString(`a (b) \ é … 日`); got != `a \(b\) \\ \351 \205 ?` {
+		t.Fatalf("unexpected %q", got)
+	}
+}
@@ -0,0 +1,45 @@
+package export
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"keys/internal/keymap"
+)
+
+// printStyle is the only style on the html-print page.
+const printStyle = `
+@page { size: A4; margin: 12.7mm; }
+body { margin: 0; font-family: Helvetica, Arial, sans-serif; }
+p { font-size: 10pt; }
+svg { display: inline-block; margin: 0 6mm 6mm 0; break-inside: avoid; }
+@media print { p { display: none; } }
+`
+
+// ContentSecurityPolicy allows the style of the html-print page by its
+// hash and nothing else, since the page doesn't use the site's assets.
+func ContentSecurityPolicy() string {
+	hash := sha256.Sum256([]byte(printStyle))
+	return "default-src 'none'; style-src 'sha256-" + base64.StdEncoding.EncodeToString(hash[:]) + "'"
+}
+
+// writeHTML draws each sheet as its own image so that the browser keeps
+// them whole when splitting the page for printing.
+func writeHTML(w io.Writer, sheets []Sheet, grid keymap.Layout) error {
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Keys</title>\n<style>" + printStyle + "</style>\n</head>\n<body>\n")
+	out.WriteString("<p>Print at 100% scale for the cards to match the keys.</p>\n")
+
+	width, height := sheetSize(grid)
+	for _, sheet := range sheets {
+		var c svgCanvas
+		drawSheet(&c, 0, 0, sheet, grid)
+		out.Write(svgDocument(&c, width, height))
+	}
+
+	out.WriteString("</body>\n</html>\n")
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"keys/internal/keymap"
+)
+
+// pdfFonts are the standard fonts every PDF reader has, so none are
+// embedded. They are named /F0, /F1 and /F2 in the order of the font
+// constants.
+var pdfFonts = []string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+type pdfCanvas struct {
+	out bytes.Buffer
+}
+
+// PDF coordinates start at the bottom left, so y is flipped.
+func (c *pdfCanvas) rect(x, y, width, height float64) {
+	fmt.Fprintf(&c.out, "%.2f %.2f %.2f %.2f re S\n", x, pageHeight-y-height, width, height)
+}
+
+func (c *pdfCanvas) text(x, y, size float64, f font, s string) {
+	fmt.Fprintf(&c.out, "BT /F%d %.1f Tf %.2f %.2f Td (%s) Tj ET\n", f, size, x, pageHeight-y, pdfString(s))
+}
+
+// pdfString escapes text for a string in a content stream. The standard
+// fonts have WinAnsi characters, which are Latin-1 apart from a few such
+// as the ellipsis. Anything else is a question mark.
+func pdfString(s string) string {
+	var out bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '…':
+			out.WriteString(`\205`)
+		case r >= ' ' && r < 0x7f:
+			out.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&out, `\%03o`, r)
+		default:
+			out.WriteByte('?')
+		}
+	}
+
+	return out.String()
+}
+
+// writePDF lays the sheets out on A4 pages.
+func writePDF(w io.Writer, sheets []Sheet, grid keymap.Layout) error {
+	var streams [][]byte
+	for _, page := range layout(sheets, grid, pageWidth-2*pageMargin, pageHeight-2*pageMargin) {
+		var c pdfCanvas
+		c.out.WriteString("0.5 w\n")
+		for _, p := range page {
+			drawSheet(&c, pageMargin+p.x, pageMargin+p.y, p.sheet, grid)
+		}
+		streams = append(streams, c.out.Bytes())
+	}
+
+	// Objects are the catalog, the page tree, the fonts, then a page and its
+	// content for each page.
+	firstPage := 3 + len(pdfFonts)
+	var objects []string
+
+	kids := ""
+	for i := range streams {
+		kids += fmt.Sprintf("%d 0 R ", firstPage+2*i)
+	}
+
+	fonts := ""
+	for i := range pdfFonts {
+		fonts += fmt.Sprintf("/F%d %d 0 R ", i, 3+i)
+	}
+
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, len(streams)),
+	)
+
+	for _, name := range pdfFonts {
+		objects = append(objects, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+	}
+
+	for i, stream := range streams {
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s>> >> /Contents %d 0 R >>", pageWidth, pageHeight, fonts, firstPage+2*i+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
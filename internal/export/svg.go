@@ -0,0 +1,61 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"keys/internal/keymap"
+)
+
+type svgCanvas struct {
+	out bytes.Buffer
+}
+
+func (c *svgCanvas) rect(x, y, width, height float64) {
+	fmt.Fprintf(&c.out, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" rx="4" fill="none" stroke="#000" stroke-width="0.5"/>`+"\n", x, y, width, height)
+}
+
+func (c *svgCanvas) text(x, y, size float64, f font, s string) {
+	var attributes string
+	switch f {
+	case bold:
+		attributes = ` font-weight="bold"`
+	case mono:
+		attributes = ` font-family="Courier, monospace"`
+	}
+
+	fmt.Fprintf(&c.out, `<text x="%.2f" y="%.2f" font-size="%.1f"%s>`, x, y, size, attributes)
+	// Writing to a buffer can't fail.
+	_ = xml.EscapeText(&c.out, []byte(s))
+	c.out.WriteString("</text>\n")
+}
+
+// svgDocument wraps what was drawn in an svg element of the given size in
+// points, which prints at that size.
+func svgDocument(c *svgCanvas, width, height float64) []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%.2fpt" height="%.2fpt" viewBox="0 0 %.2f %.2f" font-family="Helvetica, Arial, sans-serif">`+"\n", width, height, width, height)
+	out.Write(c.out.Bytes())
+	out.WriteString("</svg>\n")
+
+	return out.Bytes()
+}
+
+// writeSVG draws every sheet in one image as wide as a page.
+func writeSVG(w io.Writer, sheets []Sheet, grid keymap.Layout) error {
+	placements := layout(sheets, grid, pageWidth-2*pageMargin, 0)[0]
+
+	var c svgCanvas
+	var width, height float64
+	for _, p := range placements {
+		drawSheet(&c, p.x, p.y, p.sheet, grid)
+
+		sheetWidth, sheetHeight := sheetSize(grid)
+		width = max(width, p.x+sheetWidth)
+		height = max(height, p.y+sheetHeight)
+	}
+
+	_, err := w.Write(append([]byte(xml.Header), svgDocument(&c, width, height)...))
+	return err
+}
@@ -0,0 +1,201 @@
+// Package importer reads the bindings in the config files of other hotkey
+// tools so that they can be added to a keymap.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"keys/internal/keymap"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+)
+
+var ErrUnknownTool = errors.New("config format is not known")
+
+// Tools are the programs whose config files can be imported.
+var Tools = []string{"sxhkd", "xbindkeys"}
+
+// Binding is a key that runs a command in another tool's config.
+type Binding struct {
+	Line        int
+	PhysicalKey string
+	Command     string
+}
+
+// Problem is part of another tool's config that has no equivalent in keys,
+// so it wasn't imported.
+type Problem struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%d: %s: %s", p.Line, p.Text, p.Reason)
+}
+
+// Import reads the bindings in a config file of one of the Tools.
+func Import(tool string, raw []byte) ([]Binding, []Problem, error) {
+	switch tool {
+	case "sxhkd":
+		bindings, problems := sxhkd(raw)
+		return bindings, problems, nil
+	case "xbindkeys":
+		bindings, problems := xbindkeys(raw)
+		return bindings, problems, nil
+	default:
+		return nil, nil, fmt.Errorf("%s: %w, use one of %s", tool, ErrUnknownTool, strings.Join(Tools, ", "))
+	}
+}
+
+// Sections turns bindings into keys for km, each named after the program
+// it runs. Bindings whose physical key is already taken, or that keys
+// wouldn't accept, are left out as problems.
+func Sections(bindings []Binding, km *keymap.Keymap) ([]keymap.KeySection, []Problem) {
+	names := make(map[string]bool)
+	taken := make(map[string]string)
+
+	for _, section := range km.Content.Sections() {
+		names[section.Name()] = true
+
+		// GetKey rather than Key, which would add the option.
+		if physicalKey, err := section.GetKey("physical_key"); err == nil {
+			taken[physicalKey.String()] = section.Name()
+		}
+	}
+
+	var sections []keymap.KeySection
+	var problems []Problem
+
+	for _, b := range bindings {
+		if name, found := taken[b.PhysicalKey]; found {
+			problems = append(problems, Problem{b.Line, b.Command, fmt.Sprintf("physical key %q is already used by [%s]", b.PhysicalKey, name)})
+			continue
+		}
+
+		ks := keymap.KeySection{
+			Name: uniqueName(programName(b.Command), names),
+			Options: []keymap.Option{
+				{Name: "physical_key", Value: b.PhysicalKey},
+				{Name: "command", Value: b.Command},
+			},
+		}
+
+		if invalid := keymap.ValidateKey(ks); len(invalid) > 0 {
+			problems = append(problems, Problem{b.Line, b.Command, invalid[0].Message})
+			continue
+		}
+
+		names[ks.Name] = true
+		taken[b.PhysicalKey] = ks.Name
+		sections = append(sections, ks)
+	}
+
+	return sections, problems
+}
+
+var notNamePattern = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// programName is the base name of the program a command runs, skipping
+// environment variables set before it.
+func programName(command string) string {
+	for _, field := range strings.Fields(command) {
+		if strings.Contains(field, "=") {
+			continue
+		}
+
+		if name := notNamePattern.ReplaceAllString(filepath.Base(field), ""); name != "" {
+			return name
+		}
+	}
+
+	return "command"
+}
+
+func uniqueName(name string, names map[string]bool) string {
+	unique := name
+	for n := 2; names[unique]; n++ {
+		unique = name + "-" + strconv.Itoa(n)
+	}
+
+	return unique
+}
+
+// keysyms are the X keysyms whose evdev names aren't the keysym in capitals
+// without underscores, such as Return for KEY_ENTER. Modifiers are keys
+// like any other, pressed before the rest.
+var keysyms = map[string]string{
+	"return":                "ENTER",
+	"escape":                "ESC",
+	"prior":                 "PAGEUP",
+	"next":                  "PAGEDOWN",
+	"print":                 "SYSRQ",
+	"menu":                  "COMPOSE",
+	"period":                "DOT",
+	"bracketleft":           "LEFTBRACE",
+	"bracketright":          "RIGHTBRACE",
+	"control":               "LEFTCTRL",
+	"ctrl":                  "LEFTCTRL",
+	"control_l":             "LEFTCTRL",
+	"control_r":             "RIGHTCTRL",
+	"shift":                 "LEFTSHIFT",
+	"shift_l":               "LEFTSHIFT",
+	"shift_r":               "RIGHTSHIFT",
+	"alt":                   "LEFTALT",
+	"mod1":                  "LEFTALT",
+	"alt_l":                 "LEFTALT",
+	"alt_r":                 "RIGHTALT",
+	"super":                 "LEFTMETA",
+	"mod4":                  "LEFTMETA",
+	"super_l":               "LEFTMETA",
+	"super_r":               "RIGHTMETA",
+	"kp_add":                "KPPLUS",
+	"kp_subtract":           "KPMINUS",
+	"kp_multiply":           "KPASTERISK",
+	"kp_divide":             "KPSLASH",
+	"kp_decimal":            "KPDOT",
+	"kp_separator":          "KPCOMMA",
+	"xf86audioraisevolume":  "VOLUMEUP",
+	"xf86audiolowervolume":  "VOLUMEDOWN",
+	"xf86audiomute":         "MUTE",
+	"xf86audiomicmute":      "MICMUTE",
+	"xf86audioplay":         "PLAYPAUSE",
+	"xf86audiopause":        "PAUSECD",
+	"xf86audiostop":         "STOPCD",
+	"xf86audionext":         "NEXTSONG",
+	"xf86audioprev":         "PREVIOUSSONG",
+	"xf86monbrightnessup":   "BRIGHTNESSUP",
+	"xf86monbrightnessdown": "BRIGHTNESSDOWN",
+	"xf86calculator":        "CALC",
+	"xf86mail":              "MAIL",
+	"xf86homepage":          "HOMEPAGE",
+	"xf86search":            "SEARCH",
+	"xf86sleep":             "SLEEP",
+	"xf86poweroff":          "POWER",
+}
+
+// codeName finds the evdev code name of an X keysym, such as KEY_ENTER for
+// Return.
+func codeName(keysym string) (string, error) {
+	lower := strings.ToLower(keysym)
+	if name, found := keysyms[lower]; found {
+		return "KEY_" + name, nil
+	}
+
+	name := "KEY_" + strings.ToUpper(strings.ReplaceAll(lower, "_", ""))
+	if _, found := evdev.KEYFromString[name]; found {
+		return name, nil
+	}
+
+	return "", fmt.Errorf("%s is not a key that keys knows", keysym)
+}
+
+// physicalKey joins evdev code names into a physical_key, the way the
+// keyboard listener does as they are pressed.
+func physicalKey(codeNames []string) string {
+	return keymap.Translate(strings.Join(codeNames, ","))
+}
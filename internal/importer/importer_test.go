@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"errors"
+	"keys/internal/keymap"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSxhkd(t *testing.T) {
+	raw := `# terminal
+super + Return
+	alacritty
+
+super + {_,shift + }{1-2}
+	bspc {desktop -f,node -d} '^{1-2}'
+
+XF86Audio{Raise,Lower}Volume
+    ;pamixer -{i,d} 5
+
+super + a ; b
+    echo chained \
+      and continued
+
+super + @space
+    rofi -show run
+
+button1
+    echo click
+
+super + {a,b}
+    echo {a,b,c}
+
+ctrl + F13
+`
+
+	bindings, problems := sxhkd([]byte(raw))
+
+	want := []Binding{
+		{2, "leftmetaenter", "alacritty"},
+		{5, "leftmeta1", "bspc desktop -f '^1'"},
+		{5, "leftmeta2", "bspc desktop -f '^2'"},
+		{5, "leftmetaleftshift1", "bspc node -d '^1'"},
+		{5, "leftmetaleftshift2", "bspc node -d '^2'"},
+		{8, "volumeup", "pamixer -i 5"},
+		{8, "volumedown", "pamixer -d 5"},
+		{11, "leftmetaab", "echo chained and continued"},
+	}
+	if !reflect.DeepEqual(bindings, want) {
+		t.Errorf("expected %v, got %v", want, bindings)
+	}
+
+	wantProblems := []Problem{
+		{15, "super + @space", "release hotkeys are not supported"},
+		{18, "button1", "mouse buttons are not supported"},
+		{21, "super + {a,b}", "gives 2 hotkeys but 3 commands"},
+		{24, "ctrl + F13", "no command is given for the hotkey"},
+	}
+	if !reflect.DeepEqual(problems, wantProblems) {
+		t.Errorf("expected %v, got %v", wantProblems, problems)
+	}
+}
+
+func TestXbindkeys(t *testing.T) {
+	raw := `keystate_numlock = enable
+# mute
+"pactl set-sink-mute @DEFAULT_SINK@ toggle"
+    XF86AudioMute
+"xterm"
+    m:0x14 + c:38
+"firefox"
+    b:2
+"nothing"
+    Hyper_L + a
+"orphan"
+`
+
+	bindings, problems := xbindkeys([]byte(raw))
+
+	want := []Binding{
+		{3, "mute", "pactl set-sink-mute @DEFAULT_SINK@ toggle"},
+		{5, "leftctrla", "xterm"},
+	}
+	if !reflect.DeepEqual(bindings, want) {
+		t.Errorf("expected %v, got %v", want, bindings)
+	}
+
+	wantProblems := []Problem{
+		{1, "keystate_numlock = enable", "keys doesn't depend on the state of lock keys"},
+		{8, "b:2", "mouse buttons are not supported"},
+		{10, "Hyper_L + a", "Hyper_L is not a key that keys knows"},
+		{11, "orphan", "no keys are given for the command"},
+	}
+	if !reflect.DeepEqual(problems, wantProblems) {
+		t.Errorf("expected %v, got %v", wantProblems, problems)
+	}
+
+	if _, problems := xbindkeys([]byte("(xbindkey '(control \"a\") \"xterm\")\n")); len(problems) != 1 {
+		t.Errorf("expected Guile configs to be refused, got %v", problems)
+	}
+}
+
+func TestImportUnknownTool(t *testing.T) {
+	if _, _, err := Import("kanata", nil); !errors.Is(err, ErrUnknownTool) {
+		t.Fatalf("expected ErrUnknownTool, got %v", err)
+	}
+}
+
+func TestSections(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept beside the package so that saving can rename into place.
+	filename := filepath.Join(cwd, "keys-test-import.ini")
+	t.Cleanup(func() {
+		if err := os.Remove(filename); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(keymap.HistoryDir(filename)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := os.WriteFile(filename, []byte("[bspc]\nphysical_key = a\ncommand = echo a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := keymap.NewKeymap(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections, problems := Sections([]Binding{
+		{1, "a", "echo taken"},
+		{2, "b", "/usr/bin/bspc desktop -f next"},
+		{3, "c", "FOO=1 bspc desktop -f prev"},
+		{4, "b", "echo again"},
+	}, km)
+
+	if len(sections) != 2 || sections[0].Name != "bspc-2" || sections[1].Name != "bspc-3" {
+		t.Fatalf("unexpected sections %v", sections)
+	}
+
+	if len(problems) != 2 || problems[0].Line != 1 || problems[1].Line != 4 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	if err := km.AddKeys(sections); err != nil {
+		t.Fatal(err)
+	}
+
+	if key := km.FindKey("c"); key == nil || key.Name != "bspc-3" {
+		t.Fatal("expected the imported key to be in the config")
+	}
+
+	// The file before the save and after it.
+	revisions, err := km.Revisions(filename)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("expected the keys to be added in one save, got %d revisions: %v", len(revisions), err)
+	}
+}
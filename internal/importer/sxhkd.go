@@ -0,0 +1,205 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type sxhkdLine struct {
+	n    int
+	text string
+}
+
+// sxhkd reads an sxhkdrc: a hotkey, then its command indented on the next
+// line. Braces give several hotkeys at once, each with the command in the
+// same place in the command's braces.
+//
+//	super + {1-3}
+//	    bspc desktop -f {one,two,three}
+func sxhkd(raw []byte) ([]Binding, []Problem) {
+	var bindings []Binding
+	var problems []Problem
+
+	var hotkey *sxhkdLine
+
+	for _, line := range sxhkdLines(raw) {
+		trimmed := strings.TrimSpace(line.text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line.text, " ") && !strings.HasPrefix(line.text, "\t") {
+			if hotkey != nil {
+				problems = append(problems, Problem{hotkey.n, hotkey.text, "no command is given for the hotkey"})
+			}
+			hotkey = &sxhkdLine{line.n, trimmed}
+			continue
+		}
+
+		if hotkey == nil {
+			problems = append(problems, Problem{line.n, trimmed, "no hotkey is given before the command"})
+			continue
+		}
+
+		more, moreProblems := sxhkdBindings(*hotkey, trimmed)
+		bindings = append(bindings, more...)
+		problems = append(problems, moreProblems...)
+		hotkey = nil
+	}
+
+	if hotkey != nil {
+		problems = append(problems, Problem{hotkey.n, hotkey.text, "no command is given for the hotkey"})
+	}
+
+	return bindings, problems
+}
+
+// sxhkdLines joins lines that end in a backslash to the next one.
+func sxhkdLines(raw []byte) []sxhkdLine {
+	var lines []sxhkdLine
+	var continued *sxhkdLine
+
+	for i, text := range strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n") {
+		if continued != nil {
+			continued.text += strings.TrimLeft(text, " \t")
+		} else {
+			lines = append(lines, sxhkdLine{i + 1, text})
+			continued = &lines[len(lines)-1]
+		}
+
+		if strings.HasSuffix(continued.text, `\`) {
+			continued.text = strings.TrimSuffix(continued.text, `\`)
+		} else {
+			continued = nil
+		}
+	}
+
+	return lines
+}
+
+func sxhkdBindings(hotkey sxhkdLine, command string) ([]Binding, []Problem) {
+	hotkeys, err := expandBraces(hotkey.text)
+	if err != nil {
+		return nil, []Problem{{hotkey.n, hotkey.text, err.Error()}}
+	}
+
+	commands, err := expandBraces(command)
+	if err != nil {
+		return nil, []Problem{{hotkey.n, command, err.Error()}}
+	}
+
+	if len(commands) != 1 && len(commands) != len(hotkeys) {
+		return nil, []Problem{{hotkey.n, hotkey.text, fmt.Sprintf("gives %d hotkeys but %d commands", len(hotkeys), len(commands))}}
+	}
+
+	var bindings []Binding
+	var problems []Problem
+
+	for i, h := range hotkeys {
+		codeNames, err := sxhkdKeys(h)
+		if err != nil {
+			problems = append(problems, Problem{hotkey.n, h, err.Error()})
+			continue
+		}
+
+		c := commands[0]
+		if len(commands) > 1 {
+			c = commands[i]
+		}
+
+		// A leading semicolon makes sxhkd wait for the command, which keys
+		// always does.
+		c = strings.TrimSpace(strings.TrimPrefix(c, ";"))
+
+		bindings = append(bindings, Binding{hotkey.n, physicalKey(codeNames), c})
+	}
+
+	return bindings, problems
+}
+
+// sxhkdKeys reads a hotkey such as super + shift + a, or a chain of them
+// such as super + a ; b, which is pressed in turn like a physical_key.
+func sxhkdKeys(hotkey string) ([]string, error) {
+	var codeNames []string
+
+	for _, chord := range strings.Split(hotkey, ";") {
+		chord = strings.TrimSpace(chord)
+		if strings.HasSuffix(chord, ":") {
+			return nil, errors.New("locked chains are not supported")
+		}
+
+		for _, part := range strings.Split(chord, "+") {
+			part = strings.TrimSpace(part)
+
+			switch {
+			case part == "":
+				return nil, errors.New("a key is missing")
+			case strings.HasPrefix(part, "@"):
+				return nil, errors.New("release hotkeys are not supported")
+			case strings.HasPrefix(part, "~"):
+				return nil, errors.New("replaying the key to other programs is not supported")
+			case strings.HasPrefix(part, "!"):
+				return nil, errors.New("motion hotkeys are not supported")
+			case strings.HasPrefix(strings.ToLower(part), "button"):
+				return nil, errors.New("mouse buttons are not supported")
+			}
+
+			name, err := codeName(part)
+			if err != nil {
+				return nil, err
+			}
+			codeNames = append(codeNames, name)
+		}
+	}
+
+	return codeNames, nil
+}
+
+// expandBraces writes out every combination of the sequences in braces,
+// such as {a,b} or {1-3}. An underscore stands for nothing.
+func expandBraces(s string) ([]string, error) {
+	start := strings.Index(s, "{")
+	if start == -1 {
+		return []string{s}, nil
+	}
+
+	end := strings.Index(s[start:], "}")
+	if end == -1 {
+		return nil, errors.New("a { is not closed")
+	}
+	end += start
+
+	rests, err := expandBraces(s[end+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	var expanded []string
+	for _, item := range braceItems(s[start+1 : end]) {
+		for _, rest := range rests {
+			expanded = append(expanded, s[:start]+item+rest)
+		}
+	}
+
+	return expanded, nil
+}
+
+func braceItems(sequence string) []string {
+	var items []string
+
+	for _, item := range strings.Split(sequence, ",") {
+		switch {
+		case item == "_":
+			items = append(items, "")
+		case len(item) == 3 && item[1] == '-' && item[0] < item[2] && item[2] <= 'z':
+			for c := item[0]; c <= item[2]; c++ {
+				items = append(items, string(c))
+			}
+		default:
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
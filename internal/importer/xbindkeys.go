@@ -0,0 +1,151 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/holoplot/go-evdev"
+)
+
+// maskModifiers are the bits of an X modifier mask, as in m:0x4, that have
+// a key of their own.
+var maskModifiers = []struct {
+	bit    int
+	keysym string
+}{
+	{0x4, "control"},
+	{0x1, "shift"},
+	{0x8, "alt"},
+	{0x40, "super"},
+}
+
+// numLockMask is set in masks that xbindkeys -k prints while Num Lock is on.
+// It is a state rather than a key pressed for the binding.
+const numLockMask = 0x10
+
+// xbindkeys reads an .xbindkeysrc: a quoted command, then the keys that run
+// it on the next line.
+//
+//	"pactl set-sink-mute @DEFAULT_SINK@ toggle"
+//	    XF86AudioMute
+func xbindkeys(raw []byte) ([]Binding, []Problem) {
+	var bindings []Binding
+	var problems []Problem
+
+	var command string
+	commandLine := 0
+
+	for i, line := range strings.Split(string(raw), "\n") {
+		n := i + 1
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "("):
+			problems = append(problems, Problem{n, line, "Guile configs are not supported, only .xbindkeysrc"})
+			return nil, problems
+		case strings.HasPrefix(line, "keystate_"):
+			problems = append(problems, Problem{n, line, "keys doesn't depend on the state of lock keys"})
+		case strings.HasPrefix(line, `"`):
+			if command != "" {
+				problems = append(problems, Problem{commandLine, command, "no keys are given for the command"})
+			}
+
+			end := strings.LastIndex(line, `"`)
+			if end == 0 {
+				problems = append(problems, Problem{n, line, "the command is missing its closing quote"})
+				command = ""
+				continue
+			}
+
+			command, commandLine = line[1:end], n
+		default:
+			if command == "" {
+				problems = append(problems, Problem{n, line, "no command is given before the keys"})
+				continue
+			}
+
+			codeNames, err := xbindkeysKeys(line)
+			if err != nil {
+				problems = append(problems, Problem{n, line, err.Error()})
+			} else {
+				bindings = append(bindings, Binding{commandLine, physicalKey(codeNames), command})
+			}
+
+			command = ""
+		}
+	}
+
+	if command != "" {
+		problems = append(problems, Problem{commandLine, command, "no keys are given for the command"})
+	}
+
+	return bindings, problems
+}
+
+// xbindkeysKeys reads keys such as control+shift + q or m:0x4 + c:24.
+func xbindkeysKeys(spec string) ([]string, error) {
+	var codeNames []string
+
+	for _, part := range strings.Split(spec, "+") {
+		part = strings.TrimSpace(part)
+
+		switch {
+		case part == "":
+			return nil, errors.New("a key is missing")
+		case strings.EqualFold(part, "release"):
+			return nil, errors.New("release bindings are not supported")
+		case strings.HasPrefix(part, "b:"):
+			return nil, errors.New("mouse buttons are not supported")
+		case strings.HasPrefix(part, "m:"):
+			names, err := maskCodeNames(part[2:])
+			if err != nil {
+				return nil, err
+			}
+			codeNames = append(codeNames, names...)
+		case strings.HasPrefix(part, "c:"):
+			keycode, err := strconv.Atoi(part[2:])
+			// X keycodes are evdev codes moved up by 8.
+			name, found := evdev.KEYToString[evdev.EvCode(keycode-8)]
+			if err != nil || keycode < 8 || !found {
+				return nil, fmt.Errorf("%s is not a keycode that keys knows", part)
+			}
+			codeNames = append(codeNames, name)
+		default:
+			name, err := codeName(part)
+			if err != nil {
+				return nil, err
+			}
+			codeNames = append(codeNames, name)
+		}
+	}
+
+	return codeNames, nil
+}
+
+func maskCodeNames(mask string) ([]string, error) {
+	bits, err := strconv.ParseInt(mask, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("m:%s is not a modifier mask", mask)
+	}
+
+	bits &^= numLockMask
+
+	var codeNames []string
+	for _, modifier := range maskModifiers {
+		if int(bits)&modifier.bit != 0 {
+			name, _ := codeName(modifier.keysym)
+			codeNames = append(codeNames, name)
+			bits &^= int64(modifier.bit)
+		}
+	}
+
+	if bits != 0 {
+		return nil, fmt.Errorf("m:%s has modifiers without a key of their own", mask)
+	}
+
+	return codeNames, nil
+}
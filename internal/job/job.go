@@ -0,0 +1,176 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	Running   Status = "running"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+	Cancelled Status = "cancelled"
+	TimedOut  Status = "timed out"
+)
+
+// How many finished jobs are kept around for status polling.
+const maxFinished = 50
+
+type Job struct {
+	ID          string    `json:"id"`
+	Key         string    `json:"key"`
+	Source      string    `json:"source"`
+	Status      Status    `json:"status"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished,omitzero"`
+	ExitCode    int       `json:"exit_code"`
+	Output      string    `json:"output"`
+	ContentType string    `json:"content_type,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	State       string    `json:"state,omitempty"`
+	seq         int
+	cancel      context.CancelFunc
+}
+
+type Result struct {
+	Status      Status
+	Output      []byte
+	ContentType string
+	Err         error
+	State       string
+}
+
+type Registry struct {
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	lastID int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		jobs: make(map[string]*Job),
+	}
+}
+
+func (r *Registry) Start(key string, source string) (*Job, context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.lastID++
+	j := &Job{
+		ID:      strconv.Itoa(r.lastID),
+		Key:     key,
+		Source:  source,
+		Status:  Running,
+		Started: time.Now(),
+		seq:     r.lastID,
+		cancel:  cancel,
+	}
+
+	r.jobs[j.ID] = j
+	return j, ctx
+}
+
+func (r *Registry) Finish(j *Job, result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j.cancel()
+
+	j.Status = result.Status
+	j.Finished = time.Now()
+	j.Output = string(result.Output)
+	j.ContentType = result.ContentType
+	j.State = result.State
+
+	if result.Err != nil {
+		j.Error = result.Err.Error()
+		j.ExitCode = -1
+
+		var exitErr *exec.ExitError
+		if errors.As(result.Err, &exitErr) {
+			j.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	r.prune()
+}
+
+func (r *Registry) Cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, found := r.jobs[id]
+	if !found || j.Status != Running {
+		return false
+	}
+
+	j.cancel()
+	return true
+}
+
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, found := r.jobs[id]
+	if !found {
+		return Job{}, false
+	}
+
+	return *j, true
+}
+
+func (r *Registry) Running() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := make([]Job, 0, len(r.jobs))
+	for _, j := range r.sorted() {
+		if j.Status == Running {
+			jobs = append(jobs, *j)
+		}
+	}
+
+	return jobs
+}
+
+// Must be called with the lock held.
+func (r *Registry) sorted() []*Job {
+	jobs := make([]*Job, 0, len(r.jobs))
+	for _, j := range r.jobs {
+		jobs = append(jobs, j)
+	}
+
+	slices.SortFunc(jobs, func(a, b *Job) int {
+		return a.seq - b.seq
+	})
+
+	return jobs
+}
+
+// Must be called with the lock held.
+func (r *Registry) prune() {
+	finished := 0
+	jobs := r.sorted()
+
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if jobs[i].Status == Running {
+			continue
+		}
+
+		finished++
+		if finished > maxFinished {
+			delete(r.jobs, jobs[i].ID)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	j1, ctx1 := registry.Start("test", "api")
+	j2, _ := registry.Start("test2", "keyboard")
+
+	if j1.ID == j2.ID {
+		t.Fatal("Job ids are not unique")
+	}
+
+	running := registry.Running()
+	if len(running) != 2 || running[0].ID != j1.ID || running[1].ID != j2.ID {
+		t.Fatal("Running jobs are not listed in start order")
+	}
+
+	if !registry.Cancel(j1.ID) {
+		t.Fatal("Running job could not be cancelled")
+	}
+
+	if !errors.Is(ctx1.Err(), context.Canceled) {
+		t.Error("Cancelling a job did not cancel its context")
+	}
+
+	registry.Finish(j1, Result{Status: Cancelled})
+	registry.Finish(j2, Result{Status: Succeeded, Output: []byte("hello")})
+
+	if len(registry.Running()) != 0 {
+		t.Error("Finished jobs are still listed as running")
+	}
+
+	if registry.Cancel(j2.ID) {
+		t.Error("Finished job should not be cancellable")
+	}
+}
+
+func TestFinish(t *testing.T) {
+	registry := NewRegistry()
+
+	j, _ := registry.Start("test", "api")
+	err := exec.Command("sh", "-c", "echo hello; exit 3").Run()
+	registry.Finish(j, Result{Status: Failed, Output: []byte("hello\n"), Err: err, State: "on"})
+
+	finished, found := registry.Get(j.ID)
+	if !found {
+		t.Fatal("Finished job was not kept")
+	}
+
+	if finished.Status != Failed || finished.ExitCode != 3 || finished.Output != "hello\n" || finished.State != "on" {
+		t.Errorf("Unexpected finished job: %+v", finished)
+	}
+
+	if finished.Finished.IsZero() {
+		t.Error("Finish time was not recorded")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	registry := NewRegistry()
+
+	running, _ := registry.Start("running", "api")
+
+	var first *Job
+	for i := range maxFinished + 5 {
+		j, _ := registry.Start("test", "api")
+		if i == 0 {
+			first = j
+		}
+		registry.Finish(j, Result{Status: Succeeded})
+	}
+
+	if _, found := registry.Get(first.ID); found {
+		t.Error("Oldest finished job was not pruned")
+	}
+
+	if _, found := registry.Get(running.ID); !found {
+		t.Error("Running job was pruned")
+	}
+
+	if len(registry.jobs) != maxFinished+1 {
+		t.Errorf("Expected %d jobs, got %d", maxFinished+1, len(registry.jobs))
+	}
+}
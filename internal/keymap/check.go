@@ -0,0 +1,306 @@
+package keymap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// globalOptions are the options understood before the first section or
+// under [DEFAULT].
+var globalOptions = map[string]optionSpec{
+	"keyboard":             {kind: textOption},
+	"sound":                {kind: boolOption},
+	"sound_success":        {kind: textOption},
+	"sound_error":          {kind: textOption},
+	"sound_lock":           {kind: textOption},
+	"sound_unlock":         {kind: textOption},
+	"sound_volume":         {kind: volumeOption},
+	"sound_success_volume": {kind: volumeOption},
+	"sound_error_volume":   {kind: volumeOption},
+	"sound_lock_volume":    {kind: volumeOption},
+	"sound_unlock_volume":  {kind: volumeOption},
+	"sound_prompt_volume":  {kind: volumeOption},
+	"sound_device":         {kind: textOption},
+	"speech":               {kind: choiceOption, choices: SpeechModes},
+	"speech_engine":        {kind: choiceOption, choices: SpeechEngines},
+	"speech_voice":         {kind: textOption},
+	"speech_volume":        {kind: volumeOption},
+	"max_concurrent":       {kind: intOption, min: 0, max: 1000},
+	"notify":               {kind: choiceOption, choices: NotifyModes},
+	"history":              {kind: intOption, min: 0, max: 10000},
+	"include":              {kind: textOption},
+	"layout":               {kind: choiceOption, choices: LayoutNames},
+}
+
+// Diagnostic is a problem found in a config file. Warnings are about things
+// that work but probably not as intended; anything else stops the key or
+// option from working.
+type Diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Section string `json:"section,omitempty"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning,omitempty"`
+
+	// source orders diagnostics by the file they are in.
+	source int
+}
+
+// String leaves out the line number when it isn't known, as for files that
+// aren't INI.
+func (d Diagnostic) String() string {
+	prefix := ""
+	if d.Line > 0 {
+		prefix = fmt.Sprintf("%d: ", d.Line)
+	}
+	if d.Warning {
+		prefix += "warning: "
+	}
+
+	if d.Section != "" {
+		return fmt.Sprintf("%s[%s] %s", prefix, d.Section, d.Message)
+	}
+
+	return prefix + d.Message
+}
+
+func HasErrors(diagnostics []Diagnostic) bool {
+	return slices.ContainsFunc(diagnostics, func(d Diagnostic) bool {
+		return !d.Warning
+	})
+}
+
+// Source is the text of a config file to check and the name to report it
+// under.
+type Source struct {
+	Name string
+	Raw  []byte
+}
+
+// sectionOption is an option line and where it was found.
+type sectionOption struct {
+	Option
+	line int
+}
+
+type binding struct {
+	section string
+	key     string
+	file    string
+	line    int
+}
+
+type sectionHeading struct {
+	file string
+	line int
+}
+
+// Check looks through the text of a config file for mistakes that loading
+// it would pass over, such as misspelled options and keys that can never
+// be pressed. Diagnostics are in line order.
+func Check(raw []byte) []Diagnostic {
+	return CheckSources([]Source{{Raw: raw}})
+}
+
+// CheckSources is Check for a main config file followed by the files it
+// includes, which can clash with each other. Diagnostics are in file order,
+// then line order.
+func CheckSources(sources []Source) []Diagnostic {
+	var diagnostics []Diagnostic
+	var bindings []binding
+	seen := make(map[string]sectionHeading)
+
+	for index, source := range sources {
+		// Files in other formats are checked as their INI equivalent, so
+		// line numbers wouldn't match what is in the file.
+		converted := !isINI(source.Name)
+
+		report := func(line int, section string, warning bool, format string, args ...any) {
+			if converted {
+				line = 0
+			}
+			diagnostics = append(diagnostics, Diagnostic{File: source.Name, Line: line, Section: section, Message: fmt.Sprintf(format, args...), Warning: warning, source: index})
+		}
+
+		if converted {
+			raw, err := toINI(source.Name, source.Raw)
+			if err != nil {
+				report(0, "", false, "%s", err)
+				continue
+			}
+			source.Raw = raw
+		}
+
+		if _, err := ini.LoadSources(ini.LoadOptions{SkipUnrecognizableLines: true, AllowShadows: true}, source.Raw); err != nil {
+			report(1, "", false, "%s", strings.TrimSpace(err.Error()))
+			continue
+		}
+
+		bindings = checkSource(source, index, seen, bindings, report)
+	}
+
+	for _, current := range bindings {
+		for _, other := range bindings {
+			if current.key != other.key && strings.HasPrefix(other.key, current.key) {
+				diagnostics = append(diagnostics, Diagnostic{
+					File:    current.file,
+					Line:    current.line,
+					Section: current.section,
+					Message: fmt.Sprintf("Physical key %q starts %q of [%s], so it waits for the next key press before running", current.key, other.key, other.section),
+					Warning: true,
+					source:  slices.IndexFunc(sources, func(s Source) bool { return s.Name == current.file }),
+				})
+			}
+		}
+	}
+
+	slices.SortStableFunc(diagnostics, func(a, b Diagnostic) int {
+		if a.source != b.source {
+			return a.source - b.source
+		}
+		return a.Line - b.Line
+	})
+
+	return diagnostics
+}
+
+// checkSource checks the sections of one file, given the sections and
+// physical keys of the files before it, and returns the physical keys with
+// its own added.
+func checkSource(source Source, index int, seen map[string]sectionHeading, bindings []binding, report func(int, string, bool, string, ...any)) []binding {
+	start := 1
+
+	for i, b := range parseBlocks(source.Raw) {
+		headingLine, options, stray := readBlock(b, start)
+		start += len(b.lines)
+
+		// Values over several lines are checked as though written on one.
+		for i := range options {
+			options[i].Value = strings.ReplaceAll(options[i].Value, "\n", " ")
+		}
+
+		for _, line := range stray {
+			report(line, b.name, true, "Line is not an option, section or comment and is ignored")
+		}
+
+		if i == 0 || b.name == ini.DefaultSection {
+			if index == 0 {
+				checkGlobals(options, report)
+			} else {
+				for _, opt := range options {
+					report(opt.line, "", true, "Global options only work in the main config file, so %s is ignored", opt.Name)
+				}
+			}
+			continue
+		}
+
+		if first, found := seen[b.name]; found {
+			if first.file != source.Name {
+				report(headingLine, b.name, false, "Section is already in %s, so this one is ignored", first.file)
+				continue
+			}
+			report(headingLine, b.name, false, "Section is already used on line %d, so their options are merged", first.line)
+		} else {
+			seen[b.name] = sectionHeading{source.Name, headingLine}
+		}
+
+		ks := KeySection{Name: b.name}
+		for _, opt := range options {
+			ks.Options = append(ks.Options, opt.Option)
+		}
+
+		for _, problem := range ValidateKey(ks) {
+			line := headingLine
+			if problem.Index >= 0 {
+				line = options[problem.Index].line
+			}
+			report(line, b.name, false, "%s", problem.Message)
+		}
+
+		for _, opt := range options {
+			if opt.Name != "physical_key" || opt.Value == "" {
+				continue
+			}
+
+			index := slices.IndexFunc(bindings, func(other binding) bool { return other.key == opt.Value })
+			if index > -1 {
+				other := bindings[index]
+				if other.file == source.Name {
+					report(opt.line, b.name, false, "Physical key %q is already used by [%s]", opt.Value, other.section)
+				} else {
+					report(opt.line, b.name, false, "Physical key %q is already used by [%s] in %s", opt.Value, other.section, other.file)
+				}
+				continue
+			}
+
+			bindings = append(bindings, binding{b.name, opt.Value, source.Name, opt.line})
+		}
+	}
+
+	return bindings
+}
+
+// readBlock returns the line numbers of a block's heading, options and
+// lines that are neither, given the line the block starts on.
+func readBlock(b block, start int) (int, []sectionOption, []int) {
+	headingLine := start
+	var options []sectionOption
+	var stray []int
+
+	next := start
+	for _, lines := range logicalLines(b.lines) {
+		number := next
+		next += len(lines)
+		line := lines[0]
+
+		if _, isHeading := heading(line); isHeading {
+			headingLine = number
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" || isComment(line) {
+			continue
+		}
+
+		// Values quoted with """ can continue over several lines.
+		name, value, isOption := optionLine(strings.Join(lines, "\n"))
+		if !isOption {
+			stray = append(stray, number)
+			continue
+		}
+
+		options = append(options, sectionOption{Option{Name: name, Value: value}, number})
+	}
+
+	return headingLine, options, stray
+}
+
+func checkGlobals(options []sectionOption, report func(int, string, bool, string, ...any)) {
+	counts := make(map[string]int)
+
+	for _, opt := range options {
+		counts[opt.Name]++
+
+		spec, known := globalOptions[opt.Name]
+		if !known {
+			if _, isKeyOption := keyOptions[opt.Name]; isKeyOption {
+				report(opt.line, "", false, "%s only works under a key's section", opt.Name)
+			} else {
+				report(opt.line, "", false, "%q is not a global option", opt.Name)
+			}
+			continue
+		}
+
+		// Every include is used, so giving it more than once is expected.
+		if counts[opt.Name] == 2 && opt.Name != "include" {
+			report(opt.line, "", true, "%s is given more than once and only the first value is used", opt.Name)
+		}
+
+		if message := spec.check(strings.TrimSpace(opt.Value)); message != "" {
+			report(opt.line, "", false, "%s %s", opt.Name, message)
+		}
+	}
+}
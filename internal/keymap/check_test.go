@@ -0,0 +1,52 @@
+package keymap
+
+import (
+	"keys/internal/asset"
+	"slices"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		lines    []int
+		warnings []bool
+	}{
+		{"valid", "sound = off\n\n[a]\nphysical_key = a\ncommand = true\n", nil, nil},
+		{"unknown option", "[a]\nphysical_eky = a\ncommand = true\n", []int{2}, []bool{false}},
+		{"unknown global", "volume = 50\n[a]\ncommand = true\n", []int{1}, []bool{false}},
+		{"key option as global", "timeout = 5\n[a]\ncommand = true\n", []int{1}, []bool{false}},
+		{"bad global", "sound_volume = loud\n", []int{1}, []bool{false}},
+		{"state count", "[a]\ncommand = true\ncommand = false\nstate = on\n", []int{1}, []bool{false}},
+		{"no command", "\n[a]\nphysical_key = a\n", []int{2}, []bool{false}},
+		{"bad timeout", "[a]\ncommand = true\ntimeout = soon\n", []int{3}, []bool{false}},
+		{"duplicate binding", "[a]\nphysical_key = a\ncommand = true\n\n[b]\nphysical_key = a\ncommand = true\n", []int{6}, []bool{false}},
+		{"prefix", "[a]\nphysical_key = a\ncommand = true\n\n[ab]\nphysical_key = ab\ncommand = true\n", []int{2}, []bool{true}},
+		{"duplicate section", "[a]\ncommand = true\n[a]\ncommand = false\n", []int{3}, []bool{false}},
+		{"stray line", "[a]\ncommand = true\noops\n", []int{3}, []bool{true}},
+		{"multi-line value", "[a]\ncommand = \"\"\"echo one\necho two\"\"\"\ntimeout = 5\n", nil, nil},
+		{"rows", "[--Row 1]\n[a]\ncommand = true\n", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lines []int
+			var warnings []bool
+			for _, d := range Check([]byte(test.content)) {
+				lines = append(lines, d.Line)
+				warnings = append(warnings, d.Warning)
+			}
+
+			if !slices.Equal(lines, test.lines) || !slices.Equal(warnings, test.warnings) {
+				t.Fatalf("expected lines %v with warnings %v, got %v", test.lines, test.warnings, Check([]byte(test.content)))
+			}
+		})
+	}
+}
+
+func TestCheckSkeleton(t *testing.T) {
+	if diagnostics := Check(asset.ReadKeymapSkeleton()); len(diagnostics) > 0 {
+		t.Fatalf("the bundled config has problems: %v", diagnostics)
+	}
+}
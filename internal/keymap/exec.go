@@ -0,0 +1,244 @@
+package keymap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var sandboxRestrictions = []string{"no_network", "read_only_home"}
+
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// Mounts can only be changed from inside the new namespace, so the command is
+// run by a shell that remounts the home directory first.
+const readOnlyHomePreamble = `mount --make-rprivate / 2>/dev/null; mount --bind "$HOME" "$HOME" && mount -o remount,bind,ro "$HOME" && exec sh -c "$0"`
+
+func (k *Key) command(ctx context.Context, source string, params map[string]string) (*exec.Cmd, error) {
+	command, err := k.expandCommand(source, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.shell(ctx, command, k.Sandboxed(source))
+}
+
+// probeCommand runs the probe as the key's commands are run. It isn't
+// started from any one source, so it is sandboxed whenever the key can be.
+func (k *Key) probeCommand(ctx context.Context) (*exec.Cmd, error) {
+	return k.shell(ctx, k.Probe, len(k.Sandbox) > 0)
+}
+
+// shell prepares a shell command line with the user, priority, limits and
+// sandbox of the key.
+func (k *Key) shell(ctx context.Context, command string, sandboxed bool) (*exec.Cmd, error) {
+	args := []string{"sh", "-c", command}
+	attr := &syscall.SysProcAttr{Setpgid: true}
+
+	if sandboxed {
+		if k.User != "" {
+			return nil, errors.New("user and sandbox cannot be combined")
+		}
+
+		if slices.Contains(k.Sandbox, "read_only_home") {
+			args = []string{"sh", "-c", readOnlyHomePreamble, command}
+		}
+
+		if err := sandbox(attr, k.Sandbox); err != nil {
+			return nil, err
+		}
+	}
+
+	if k.IONice != "" {
+		prefix, err := ioniceArgs(k.IONice)
+		if err != nil {
+			return nil, err
+		}
+		args = append(prefix, args...)
+	}
+
+	if k.Nice != 0 {
+		args = append([]string{"nice", "-n", strconv.Itoa(k.Nice)}, args...)
+	}
+
+	if len(k.Limits) > 0 {
+		prefix := []string{"systemd-run", "--user", "--scope", "--quiet", "--collect"}
+		for _, limit := range k.Limits {
+			prefix = append(prefix, "-p", limit)
+		}
+		args = append(append(prefix, "--"), args...)
+	}
+
+	if k.User != "" {
+		credential, err := credential(k.User)
+		if err != nil {
+			return nil, err
+		}
+		attr.Credential = credential
+	}
+
+	// #nosec [204] [-- The command being run intentionally comes from a user-supplied value.]
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+
+	// The command gets its own process group so that anything it spawns is
+	// stopped along with it.
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error {
+		return terminate(cmd.Process.Pid, k.KillGrace)
+	}
+	cmd.WaitDelay = k.KillGrace + time.Second
+
+	return cmd, nil
+}
+
+// Sends SIGTERM to a process group, followed by SIGKILL if anything in it is
+// still around after the grace period. The group is watched until then,
+// since once it has gone its ID can be given to another.
+func terminate(pgid int, grace time.Duration) error {
+	err := syscall.Kill(-pgid, syscall.SIGTERM)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+
+	go func() {
+		deadline := time.NewTimer(grace)
+		defer deadline.Stop()
+
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !groupAlive(pgid) {
+					return
+				}
+			case <-deadline.C:
+				if groupAlive(pgid) {
+					_ = syscall.Kill(-pgid, syscall.SIGKILL)
+				}
+				return
+			}
+		}
+	}()
+
+	return err
+}
+
+// groupAlive checks for a process group by sending it no signal.
+func groupAlive(pgid int) bool {
+	return !errors.Is(syscall.Kill(-pgid, 0), syscall.ESRCH)
+}
+
+// clientSources are claimed by whichever client sends the request, so the
+// sandbox treats them as one.
+var clientSources = []string{"api", "browser"}
+
+// Sandboxed reports whether the sandbox applies to a trigger from source.
+// Any client can say it is the browser or not, so a key sandboxed for
+// either of the API and the browser is sandboxed for both.
+func (k *Key) Sandboxed(source string) bool {
+	if len(k.Sandbox) == 0 {
+		return false
+	}
+
+	if slices.Contains(clientSources, source) && slices.ContainsFunc(k.SandboxSources, func(s string) bool { return slices.Contains(clientSources, s) }) {
+		return true
+	}
+
+	return len(k.SandboxSources) == 0 || slices.Contains(k.SandboxSources, source)
+}
+
+func sandbox(attr *syscall.SysProcAttr, restrictions []string) error {
+	for _, restriction := range restrictions {
+		if !slices.Contains(sandboxRestrictions, restriction) {
+			return fmt.Errorf("unknown sandbox restriction %q", restriction)
+		}
+	}
+
+	// The current user keeps its identity inside the user namespace unless
+	// mounts have to be changed, which needs root within the namespace.
+	uid, gid := os.Getuid(), os.Getgid()
+	containerUid, containerGid := uid, gid
+
+	attr.Cloneflags = syscall.CLONE_NEWUSER
+
+	if slices.Contains(restrictions, "no_network") {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	if slices.Contains(restrictions, "read_only_home") {
+		attr.Cloneflags |= syscall.CLONE_NEWNS
+		containerUid, containerGid = 0, 0
+	}
+
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: containerUid, HostID: uid, Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: containerGid, HostID: gid, Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+
+	return nil
+}
+
+func ioniceArgs(value string) ([]string, error) {
+	name, level, hasLevel := strings.Cut(value, ":")
+
+	class, found := ioniceClasses[name]
+	if !found {
+		return nil, fmt.Errorf("unknown ionice class %q", name)
+	}
+
+	args := []string{"ionice", "-c", class}
+
+	if hasLevel {
+		if n, err := strconv.Atoi(level); err != nil || n < 0 || n > 7 {
+			return nil, fmt.Errorf("ionice level %q should be between 0 and 7", level)
+		}
+		args = append(args, "-n", level)
+	}
+
+	return args, nil
+}
+
+func credential(username string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, groupId := range groupIds {
+		if g, err := strconv.ParseUint(groupId, 10, 32); err == nil {
+			credential.Groups = append(credential.Groups, uint32(g))
+		}
+	}
+
+	return credential, nil
+}
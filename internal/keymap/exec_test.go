@@ -0,0 +1,233 @@
+package keymap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+func loadSectionFromFixture(t *testing.T, filename string, section string) *Key {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := ini.LoadOptions{
+		SkipUnrecognizableLines: true,
+		AllowShadows:            true,
+	}
+
+	ini, err := ini.LoadSources(options, filepath.Join(wd, "../../testdata", filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ini.GetSection(section)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewKeyFromSection(s, "")
+}
+
+func TestCommandWrapping(t *testing.T) {
+	key := loadSectionFromFixture(t, "key-exec.ini", "test")
+
+	cmd, err := key.command(context.Background(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"systemd-run", "--user", "--scope", "--quiet", "--collect",
+		"-p", "MemoryMax=100M", "-p", "CPUQuota=50%", "--",
+		"nice", "-n", "10",
+		"ionice", "-c", "2", "-n", "7",
+		"sh", "-c", "echo hello",
+	}
+
+	if !slices.Equal(cmd.Args, want) {
+		t.Errorf("Unexpected command wrapping: %v", cmd.Args)
+	}
+
+	if !cmd.SysProcAttr.Setpgid {
+		t.Error("Command is not in its own process group")
+	}
+}
+
+func TestIoniceArgs(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{value: "idle", valid: true},
+		{value: "realtime:0", valid: true},
+		{value: "best-effort:8", valid: false},
+		{value: "fast", valid: false},
+	}
+
+	for _, tt := range tests {
+		_, err := ioniceArgs(tt.value)
+		if tt.valid && err != nil {
+			t.Errorf("Valid ionice %q was rejected: %v", tt.value, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("Invalid ionice %q was accepted", tt.value)
+		}
+	}
+}
+
+func TestSandboxed(t *testing.T) {
+	key := loadSectionFromFixture(t, "key-exec.ini", "sandboxed")
+
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{source: "browser", want: true},
+		{source: "api", want: true},
+		{source: "keyboard", want: false},
+	}
+
+	for _, tt := range tests {
+		if key.Sandboxed(tt.source) != tt.want {
+			t.Errorf("Sandboxed for %s should be %t", tt.source, tt.want)
+		}
+	}
+
+	key = keymapFromContent(t, "[api]\ncommand = echo api\nsandbox = no_network\nsandbox_sources = api\n").FindKey("api")
+	if !key.Sandboxed("browser") {
+		t.Error("A key sandboxed for the API should be sandboxed for the browser")
+	}
+
+	key = keymapFromContent(t, "[browser]\ncommand = echo browser\nsandbox = no_network\nsandbox_sources = browser\n").FindKey("browser")
+	if !key.Sandboxed("api") {
+		t.Error("A key sandboxed for the browser should be sandboxed for the API")
+	}
+}
+
+func TestSandboxNoNetwork(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	key := loadSectionFromFixture(t, "key-exec.ini", "sandboxed")
+
+	stdout, err := key.RunCommandContext(context.Background(), "browser", nil)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) {
+		t.Skip("user namespaces are not available")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the loopback interface exists in a new network namespace.
+	for line := range strings.Lines(string(stdout)) {
+		name, _, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && name != "lo" && !strings.Contains(name, "|") {
+			t.Errorf("Sandboxed command could see network interface %s", name)
+		}
+	}
+}
+
+func processGone(pid string) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", pid, "stat"))
+	if err != nil {
+		return true
+	}
+
+	// Zombies have exited but have not been reaped by their new parent.
+	fields := strings.Fields(string(stat))
+	return len(fields) > 2 && fields[2] == "Z"
+}
+
+func TestProcessGroupCleanup(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	key := loadSectionFromFixture(t, "key-process.ini", "orphan")
+
+	stdout, err := key.RunCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid := strings.TrimSpace(string(stdout))
+	if pid == "" {
+		t.Fatal("Background job pid was not printed")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if !processGone(pid) {
+		t.Errorf("Background job %s outlived the command", pid)
+	}
+}
+
+func TestTimeoutStopsProcessGroup(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	key := loadSectionFromFixture(t, "key-process.ini", "timeout")
+
+	start := time.Now()
+	_, err := key.RunCommand()
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Command took %s to stop", elapsed)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	// #nosec [204] [-- A fixed command.]
+	cmd := exec.Command("sh", "-c", `trap "" TERM; sleep 5`)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the shell time to ignore SIGTERM.
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := terminate(cmd.Process.Pid, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = cmd.Wait()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Process group ignoring SIGTERM took %s to stop", elapsed)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	key := loadSectionFromFixture(t, "key-process.ini", "detach")
+
+	stdout, err := key.RunCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stdout) != 0 {
+		t.Errorf("Detached command should not produce output")
+	}
+}
@@ -0,0 +1,350 @@
+package keymap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Document is a keymap apart from how it is written: the global options,
+// then the keys and rows in order. Options given more than once appear once
+// per value, as in KeySection.
+type Document struct {
+	Options  []Option
+	Sections []KeySection
+}
+
+// Format reads and writes a Document in one kind of config file.
+type Format interface {
+	Decode(raw []byte) (Document, error)
+	Encode(doc Document) ([]byte, error)
+}
+
+// formats are chosen by file extension. Anything not listed is read as INI.
+var formats = map[string]Format{
+	".ini":  iniFormat{},
+	".json": jsonFormat{},
+	".toml": tomlFormat{},
+	".yaml": yamlFormat{},
+	".yml":  yamlFormat{},
+}
+
+// FormatFor picks the format of a config file from its extension.
+func FormatFor(filename string) Format {
+	if format, found := formats[strings.ToLower(filepath.Ext(filename))]; found {
+		return format
+	}
+
+	return iniFormat{}
+}
+
+func isINI(filename string) bool {
+	return FormatFor(filename) == iniFormat{}
+}
+
+// Convert rewrites the text of a config file in another format.
+func Convert(from Format, to Format, raw []byte) ([]byte, error) {
+	doc, err := from.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return to.Encode(doc)
+}
+
+// toINI returns the text of a config file as INI, which is what the keymap
+// is loaded from and edited as whatever the file's format.
+func toINI(filename string, raw []byte) ([]byte, error) {
+	return Convert(FormatFor(filename), iniFormat{}, raw)
+}
+
+// fromINI is the reverse of toINI.
+func fromINI(filename string, raw []byte) ([]byte, error) {
+	return Convert(iniFormat{}, FormatFor(filename), raw)
+}
+
+type iniFormat struct{}
+
+// Decode reads the file as written rather than as loaded, which would group
+// the values of an option given more than once.
+func (iniFormat) Decode(raw []byte) (Document, error) {
+	if _, err := ini.LoadSources(loadOptions, raw); err != nil {
+		return Document{}, err
+	}
+
+	var doc Document
+	start := 1
+
+	for i, b := range parseBlocks(raw) {
+		_, options, _ := readBlock(b, start)
+		start += len(b.lines)
+
+		if i == 0 || b.name == ini.DefaultSection {
+			for _, opt := range options {
+				doc.Options = append(doc.Options, opt.Option)
+			}
+			continue
+		}
+
+		ks := KeySection{Name: b.name, Options: []Option{}}
+		for _, opt := range options {
+			ks.Options = append(ks.Options, opt.Option)
+		}
+		doc.Sections = append(doc.Sections, ks)
+	}
+
+	return doc, nil
+}
+
+// Encode is the reverse of Decode. It only returns the text the formats have
+// in common, so comments aren't carried over.
+func (iniFormat) Encode(doc Document) ([]byte, error) {
+	var out bytes.Buffer
+
+	for _, opt := range doc.Options {
+		out.WriteString(formatOption(opt.Name, opt.Value) + "\n")
+	}
+
+	for i, ks := range doc.Sections {
+		if i > 0 || len(doc.Options) > 0 {
+			out.WriteString("\n")
+		}
+
+		out.WriteString("[" + ks.Name + "]\n")
+		for _, opt := range ks.Options {
+			out.WriteString(formatOption(opt.Name, opt.Value) + "\n")
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// sectionsField holds the keys and rows in the formats other than INI.
+const sectionsField = "keys"
+
+// groupOptions collects the values of each option, in the order each name
+// first appears, for the formats that write them as lists.
+func groupOptions(options []Option) ([]string, map[string][]string) {
+	var names []string
+	values := make(map[string][]string)
+
+	for _, opt := range options {
+		if _, seen := values[opt.Name]; !seen {
+			names = append(names, opt.Name)
+		}
+		values[opt.Name] = append(values[opt.Name], opt.Value)
+	}
+
+	return names, values
+}
+
+// jsonFormat is an object of global options with the keys and rows as a
+// list under "keys". Each of those is an object with a name and its
+// options. Options given more than once are lists.
+//
+//	{"sound": "off", "keys": [{"name": "lock", "physical_key": "l", "command": ["lock", "unlock"]}]}
+type jsonFormat struct{}
+
+func (jsonFormat) Decode(raw []byte) (Document, error) {
+	members, err := jsonObject(raw)
+	if err != nil {
+		return Document{}, err
+	}
+
+	var doc Document
+	for _, member := range members {
+		if member.name != sectionsField {
+			options, err := jsonOptions(member)
+			if err != nil {
+				return Document{}, err
+			}
+			doc.Options = append(doc.Options, options...)
+			continue
+		}
+
+		var sections []json.RawMessage
+		if err := json.Unmarshal(member.value, &sections); err != nil {
+			return Document{}, fmt.Errorf("%s must be a list of objects", sectionsField)
+		}
+
+		for i, raw := range sections {
+			ks, err := jsonSection(raw)
+			if err != nil {
+				return Document{}, fmt.Errorf("%s %d: %w", sectionsField, i+1, err)
+			}
+			doc.Sections = append(doc.Sections, ks)
+		}
+	}
+
+	return doc, nil
+}
+
+func (jsonFormat) Encode(doc Document) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString("{\n")
+
+	fields := jsonFields(doc.Options)
+	for _, field := range fields {
+		out.WriteString("  " + field + ",\n")
+	}
+
+	out.WriteString(`  "` + sectionsField + `": [`)
+	for i, ks := range doc.Sections {
+		if i > 0 {
+			out.WriteString(",")
+		}
+
+		out.WriteString("\n    {\n      \"name\": " + jsonText(ks.Name))
+		for _, field := range jsonFields(ks.Options) {
+			out.WriteString(",\n      " + field)
+		}
+		out.WriteString("\n    }")
+	}
+
+	if len(doc.Sections) > 0 {
+		out.WriteString("\n  ")
+	}
+	out.WriteString("]\n}\n")
+
+	return out.Bytes(), nil
+}
+
+type jsonMember struct {
+	name  string
+	value json.RawMessage
+}
+
+// jsonObject reads the members of an object in the order written, which a
+// map would lose.
+func jsonObject(raw []byte) ([]jsonMember, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, errors.New("config must be a JSON object")
+	}
+
+	var members []jsonMember
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var member jsonMember
+		member.name, _ = token.(string)
+		if err := decoder.Decode(&member.value); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func jsonSection(raw json.RawMessage) (KeySection, error) {
+	members, err := jsonObject(raw)
+	if err != nil {
+		return KeySection{}, errors.New("must be an object")
+	}
+
+	ks := KeySection{Options: []Option{}}
+	for _, member := range members {
+		if member.name == "name" {
+			if err := json.Unmarshal(member.value, &ks.Name); err != nil {
+				return KeySection{}, errors.New("name must be a string")
+			}
+			continue
+		}
+
+		options, err := jsonOptions(member)
+		if err != nil {
+			return KeySection{}, err
+		}
+		ks.Options = append(ks.Options, options...)
+	}
+
+	if ks.Name == "" {
+		return KeySection{}, errors.New("name is missing")
+	}
+
+	return ks, nil
+}
+
+// jsonOptions reads an option's value, or values if it is a list. Numbers
+// and booleans are kept as written, since that is how INI has them.
+func jsonOptions(member jsonMember) ([]Option, error) {
+	var values []json.RawMessage
+	if err := json.Unmarshal(member.value, &values); err != nil {
+		values = []json.RawMessage{member.value}
+	}
+
+	var options []Option
+	for _, raw := range values {
+		var value any
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			options = append(options, Option{Name: member.name, Value: v})
+		case json.Number, bool:
+			options = append(options, Option{Name: member.name, Value: fmt.Sprint(v)})
+		default:
+			return nil, fmt.Errorf("%s must be a string, number, boolean or a list of them", member.name)
+		}
+	}
+
+	return options, nil
+}
+
+// jsonFields formats options as object members, in the order each name
+// first appears. Values are always strings, since INI doesn't say which
+// options are numbers.
+func jsonFields(options []Option) []string {
+	names, values := groupOptions(options)
+
+	var fields []string
+	for _, name := range names {
+		var encoded []string
+		for _, value := range values[name] {
+			encoded = append(encoded, jsonText(value))
+		}
+
+		value := encoded[0]
+		if len(encoded) > 1 {
+			value = "[" + strings.Join(encoded, ", ") + "]"
+		}
+
+		fields = append(fields, jsonText(name)+": "+value)
+	}
+
+	return fields
+}
+
+// jsonText encodes a string, leaving characters such as & and > as they
+// are since they are common in commands.
+func jsonText(value string) string {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+
+	// Encoding a string can't fail.
+	_ = encoder.Encode(value)
+
+	return strings.TrimSuffix(out.String(), "\n")
+}
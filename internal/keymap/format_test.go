@@ -0,0 +1,311 @@
+package keymap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const formatFixture = `; A comment that JSON can't keep
+sound = off
+include = a.ini
+include = b.ini
+
+[--media]
+
+[lock]
+physical_key = l
+state = unlocked
+command = lock && echo "locked"
+state = locked
+command = unlock
+timeout = 5
+`
+
+const formatFixtureJSON = `{
+  "sound": "off",
+  "include": ["a.ini", "b.ini"],
+  "keys": [
+    {
+      "name": "--media"
+    },
+    {
+      "name": "lock",
+      "physical_key": "l",
+      "state": ["unlocked", "locked"],
+      "command": ["lock && echo \"locked\"", "unlock"],
+      "timeout": "5"
+    }
+  ]
+}
+`
+
+const formatFixtureTOML = `sound = "off"
+include = ["a.ini", "b.ini"]
+
+[[keys]]
+name = "--media"
+
+[[keys]]
+name = "lock"
+physical_key = "l"
+state = ["unlocked", "locked"]
+command = ["lock && echo \"locked\"", "unlock"]
+timeout = "5"
+`
+
+const formatFixtureYAML = `sound: off
+include:
+  - a.ini
+  - b.ini
+keys:
+  - name: --media
+  - name: lock
+    physical_key: l
+    state:
+      - unlocked
+      - locked
+    command:
+      - lock && echo "locked"
+      - unlock
+    timeout: "5"
+`
+
+func TestFormatFor(t *testing.T) {
+	for filename, want := range map[string]Format{
+		"keys.ini":  iniFormat{},
+		"keys":      iniFormat{},
+		"keys.JSON": jsonFormat{},
+		"keys.toml": tomlFormat{},
+		"keys.yaml": yamlFormat{},
+		"keys.yml":  yamlFormat{},
+	} {
+		if got := FormatFor(filename); got != want {
+			t.Errorf("expected %T for %s, got %T", want, filename, got)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	// Values of the same option end up together, which doesn't change what
+	// they mean.
+	want := strings.Replace(strings.TrimPrefix(formatFixture, "; A comment that JSON can't keep\n"),
+		"state = unlocked\ncommand = lock && echo \"locked\"\nstate = locked\n",
+		"state = unlocked\nstate = locked\ncommand = lock && echo \"locked\"\n", 1)
+
+	for format, fixture := range map[Format]string{
+		jsonFormat{}: formatFixtureJSON,
+		tomlFormat{}: formatFixtureTOML,
+		yamlFormat{}: formatFixtureYAML,
+	} {
+		converted, err := Convert(iniFormat{}, format, []byte(formatFixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(converted) != fixture {
+			t.Fatalf("expected:\n%s\ngot:\n%s", fixture, converted)
+		}
+
+		back, err := Convert(format, iniFormat{}, converted)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(back) != want {
+			t.Fatalf("expected:\n%s\ngot:\n%s", want, back)
+		}
+	}
+}
+
+func TestJSONDecode(t *testing.T) {
+	doc, err := jsonFormat{}.Decode([]byte(`{"max_concurrent": 3, "keys": [{"name": "a", "output": false, "command": ["x", null]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Options) != 1 || doc.Options[0] != (Option{"max_concurrent", "3"}) {
+		t.Fatalf("unexpected options %v", doc.Options)
+	}
+
+	if options := doc.Sections[0].Options; len(options) != 2 || options[0] != (Option{"output", "false"}) || options[1] != (Option{"command", "x"}) {
+		t.Fatalf("unexpected key options %v", options)
+	}
+
+	for raw, want := range map[string]string{
+		`[]`:                           "config must be a JSON object",
+		`{"keys": {}}`:                 "keys must be a list of objects",
+		`{"keys": [{"command": "x"}]}`: "keys 1: name is missing",
+		`{"keys": [{"name": "a", "command": {"x": 1}}]}`: "keys 1: command must be a string, number, boolean or a list of them",
+	} {
+		if _, err := (jsonFormat{}).Decode([]byte(raw)); err == nil || err.Error() != want {
+			t.Errorf("expected %q for %s, got %v", want, raw, err)
+		}
+	}
+}
+
+func TestTOMLDecode(t *testing.T) {
+	doc, err := tomlFormat{}.Decode([]byte("max_concurrent = 3\n\n[[keys]]\nname = \"a\"\noutput = false\ncommand = [\"x\", 1.5]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Options) != 1 || doc.Options[0] != (Option{"max_concurrent", "3"}) {
+		t.Fatalf("unexpected options %v", doc.Options)
+	}
+
+	if options := doc.Sections[0].Options; len(options) != 3 || options[0] != (Option{"output", "false"}) || options[2] != (Option{"command", "1.5"}) {
+		t.Fatalf("unexpected key options %v", options)
+	}
+
+	for raw, want := range map[string]string{
+		"keys = \"a\"\n":                              "keys must be an array of tables, written [[keys]]",
+		"[[keys]]\ncommand = \"x\"\n":                 "keys 1: name is missing",
+		"[[keys]]\nname = 1\n":                        "keys 1: name must be a string",
+		"[[keys]]\nname = \"a\"\ncommand = {x = 1}\n": "keys 1: command must be a string, number, boolean or an array of them",
+	} {
+		if _, err := (tomlFormat{}).Decode([]byte(raw)); err == nil || err.Error() != want {
+			t.Errorf("expected %q for %q, got %v", want, raw, err)
+		}
+	}
+}
+
+func TestYAMLDecode(t *testing.T) {
+	doc, err := yamlFormat{}.Decode([]byte("max_concurrent: 3\nkeys:\n  - name: a\n    output: no\n    command: [x, ~]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doc.Options) != 1 || doc.Options[0] != (Option{"max_concurrent", "3"}) {
+		t.Fatalf("unexpected options %v", doc.Options)
+	}
+
+	if options := doc.Sections[0].Options; len(options) != 2 || options[0] != (Option{"output", "no"}) || options[1] != (Option{"command", "x"}) {
+		t.Fatalf("unexpected key options %v", options)
+	}
+
+	if doc, err := (yamlFormat{}).Decode(nil); err != nil || len(doc.Sections) != 0 {
+		t.Fatalf("expected an empty file to have no keys, got %v: %v", doc, err)
+	}
+
+	for raw, want := range map[string]string{
+		"- a\n":                   "config must be a YAML mapping",
+		"keys: {}\n":              "keys must be a list of mappings",
+		"keys:\n  - command: x\n": "keys 1: name is missing",
+		"keys:\n  - name: [a]\n":  "keys 1: name must be a string",
+		"keys:\n  - name: a\n    command: {x: 1}\n": "keys 1: command must be a string, number, boolean or a list of them",
+	} {
+		if _, err := (yamlFormat{}).Decode([]byte(raw)); err == nil || err.Error() != want {
+			t.Errorf("expected %q for %q, got %v", want, raw, err)
+		}
+	}
+}
+
+func TestJSONKeymap(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(cwd, "keys-test-format.json")
+	t.Cleanup(func() {
+		if err := os.Remove(filename); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(HistoryDir(filename)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Cleanup(clearCache)
+
+	content := `{"keys": [{"name": "lock", "physical_key": "l", "state": ["unlocked", "locked"], "command": ["lock", "unlock"]}]}`
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := NewKeymap(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key := km.FindKeyByName("lock"); key == nil || len(key.Commands) != 2 {
+		t.Fatal("expected the key from the JSON file")
+	}
+
+	if err := km.AddKey(KeySection{Name: "hello", Options: []Option{{"physical_key", "h"}, {"command", "echo hello"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	km.SetSound(false)
+	if err := km.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := jsonFormat{}.Decode(km.Raw())
+	if err != nil {
+		t.Fatalf("the file is no longer JSON: %v\n%s", err, km.Raw())
+	}
+
+	if len(doc.Sections) != 2 || doc.Sections[1].Name != "hello" || len(doc.Options) != 1 || doc.Options[0].Value != "off" {
+		t.Fatalf("unexpected file:\n%s", km.Raw())
+	}
+
+	diagnostics := km.CheckEdit(filename, []byte(`{"keys": [{"name": "a", "comand": "x"}]}`))
+	if len(diagnostics) == 0 || diagnostics[0].Line != 0 {
+		t.Fatalf("expected diagnostics without line numbers, got %v", diagnostics)
+	}
+
+	revisions, err := km.Revisions(filename)
+	if err != nil || len(revisions) == 0 {
+		t.Fatalf("expected revisions, got %v: %v", revisions, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(HistoryDir(filename), revisions[0].ID+".json")); err != nil {
+		t.Fatalf("expected the revision to be kept as JSON: %v", err)
+	}
+
+	if err := km.Restore(filename, revisions[len(revisions)-1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := km.parse(filename, km.Raw()); err != nil {
+		t.Fatalf("the restored file doesn't load: %v", err)
+	}
+}
+
+func TestTOMLAndYAMLKeymaps(t *testing.T) {
+	t.Cleanup(clearCache)
+
+	for name, content := range map[string]string{
+		"keys.toml": formatFixtureTOML,
+		"keys.yaml": formatFixtureYAML,
+	} {
+		filename := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		km, err := NewKeymap(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key := km.FindKeyByName("lock"); key == nil || len(key.Commands) != 2 {
+			t.Fatalf("expected the key from %s", name)
+		}
+
+		km.SetSound(true)
+		if err := km.Write(); err != nil {
+			t.Fatal(err)
+		}
+
+		doc, err := FormatFor(name).Decode(km.Raw())
+		if err != nil {
+			t.Fatalf("%s is no longer in its format: %v\n%s", name, err, km.Raw())
+		}
+
+		if len(doc.Sections) != 2 || doc.Options[0] != (Option{"sound", "on"}) {
+			t.Fatalf("unexpected %s:\n%s", name, km.Raw())
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package keymap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// DefaultHistory is how many revisions of the config file are kept unless
+// the history option says otherwise.
+const DefaultHistory = 50
+
+const revisionFormat = "20060102-150405.000000000"
+
+var revisionPattern = regexp.MustCompile(`^\d{8}-\d{6}\.\d{9}$`)
+
+var (
+	ErrRevisionNotFound = errors.New("no revision with that id")
+	ErrNoRevision       = errors.New("not enough revisions to go back that far")
+)
+
+// Revision is a copy of the config file as it was after a save, or before
+// one if it had been changed by other means.
+type Revision struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// HistoryDir is where revisions of a config file are kept: a directory
+// beside it, named after it.
+func HistoryDir(filename string) string {
+	base := filepath.Base(filename)
+	return filepath.Join(filepath.Dir(filename), strings.TrimSuffix(base, filepath.Ext(base))+"-history")
+}
+
+// revisionFile is where a revision of a config file is kept. It has the
+// extension of the file, so that it is read in the same format.
+func revisionFile(path string, id string) string {
+	return filepath.Join(HistoryDir(path), id+filepath.Ext(path))
+}
+
+// Revisions lists the kept revisions of one of the config files, newest
+// first.
+func (km *Keymap) Revisions(path string) ([]Revision, error) {
+	entries, err := os.ReadDir(HistoryDir(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []Revision
+	for _, entry := range entries {
+		id, found := strings.CutSuffix(entry.Name(), filepath.Ext(path))
+		if !found || !revisionPattern.MatchString(id) {
+			continue
+		}
+
+		t, err := time.Parse(revisionFormat, id)
+		if err != nil {
+			continue
+		}
+
+		revisions = append(revisions, Revision{ID: id, Time: t})
+	}
+
+	slices.Reverse(revisions)
+	return revisions, nil
+}
+
+func (km *Keymap) ReadRevision(path string, id string) ([]byte, error) {
+	if !revisionPattern.MatchString(id) {
+		return nil, ErrRevisionNotFound
+	}
+
+	content, err := os.ReadFile(revisionFile(path, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrRevisionNotFound
+	}
+
+	return content, err
+}
+
+// Restore makes a revision the current content of a config file. The
+// content it replaces is kept as a revision too, so restoring can be undone.
+func (km *Keymap) Restore(path string, id string) error {
+	content, err := km.ReadRevision(path, id)
+	if err != nil {
+		return err
+	}
+
+	return km.WriteRawFile(path, content)
+}
+
+// Rollback restores the config from some number of saves ago and returns
+// the revision used. It works on the file alone, so that a config that no
+// longer loads can still be rolled back.
+func Rollback(filename string, steps int) (Revision, error) {
+	km := &Keymap{Filename: filename, LoadOptions: loadOptions, History: DefaultHistory}
+	if content, err := km.parse(filename, km.Raw()); err == nil {
+		km.History = option(content.Section(ini.DefaultSection), "history").MustInt(DefaultHistory)
+	}
+
+	revisions, err := km.Revisions(filename)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	// The newest revision is normally the current config, in which case it
+	// doesn't count as a step back.
+	index := steps - 1
+	if len(revisions) > 0 {
+		if latest, err := km.ReadRevision(filename, revisions[0].ID); err == nil && bytes.Equal(latest, km.Raw()) {
+			index = steps
+		}
+	}
+
+	if steps < 1 || index >= len(revisions) {
+		return Revision{}, ErrNoRevision
+	}
+
+	content, err := km.ReadRevision(filename, revisions[index].ID)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	err = km.save(filename, func(path string) error {
+		return os.WriteFile(path, content, 0600)
+	})
+
+	return revisions[index], err
+}
+
+// keep adds content to the history unless it is the same as the newest
+// revision, then forgets the oldest revisions beyond the limit.
+func (km *Keymap) keep(filename string, content []byte) error {
+	if km.History <= 0 {
+		return nil
+	}
+
+	revisions, err := km.Revisions(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(revisions) > 0 {
+		latest, err := km.ReadRevision(filename, revisions[0].ID)
+		if err == nil && bytes.Equal(latest, content) {
+			return nil
+		}
+	}
+
+	dir := HistoryDir(filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	id := time.Now().UTC().Format(revisionFormat)
+	if err := os.WriteFile(revisionFile(filename, id), content, 0600); err != nil {
+		return fmt.Errorf("could not keep revision: %w", err)
+	}
+
+	revisions = append([]Revision{{ID: id}}, revisions...)
+	for _, old := range revisions[min(km.History, len(revisions)):] {
+		if err := os.Remove(revisionFile(filename, old.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepAround records a config file before and after a save. The one before
+// is normally the newest revision already, unless the file was edited by
+// hand.
+func (km *Keymap) keepAround(filename string, before []byte, save func() error) error {
+	if before != nil {
+		if err := km.keep(filename, before); err != nil {
+			log.Println(err)
+		}
+	}
+
+	if err := save(); err != nil {
+		return err
+	}
+
+	after, err := os.ReadFile(filename)
+	if err == nil {
+		err = km.keep(filename, after)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
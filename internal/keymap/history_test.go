@@ -0,0 +1,127 @@
+package keymap
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	km := keymapFromContent(t, "[a]\ncommand = echo 1\n")
+
+	for _, content := range []string{"[a]\ncommand = echo 2\n", "[a]\ncommand = echo 3\n"} {
+		if err := km.WriteRaw([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := km.Revisions(km.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The original is kept before the first save replaces it.
+	if len(revisions) != 3 {
+		t.Fatalf("expected 3 revisions, got %d", len(revisions))
+	}
+
+	oldest, err := km.ReadRevision(km.Filename, revisions[2].ID)
+	if err != nil || string(oldest) != "[a]\ncommand = echo 1\n" {
+		t.Fatalf("unexpected oldest revision %q: %v", oldest, err)
+	}
+
+	if err := km.Restore(km.Filename, revisions[2].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(km.Raw()) != "[a]\ncommand = echo 1\n" {
+		t.Fatalf("restore did not replace the config: %q", km.Raw())
+	}
+
+	if _, err := km.ReadRevision(km.Filename, "../keys"); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("expected ErrRevisionNotFound, got %v", err)
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	km := keymapFromContent(t, "history = 2\n[a]\ncommand = echo 1\n")
+
+	for _, content := range []string{"history = 2\n[a]\ncommand = echo 2\n", "history = 2\n[a]\ncommand = echo 3\n"} {
+		if err := km.WriteRaw([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := km.Revisions(km.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revisions))
+	}
+}
+
+func TestSettingsKeepNoRevision(t *testing.T) {
+	km := keymapFromContent(t, "history = 2\n[a]\ncommand = echo 1\n")
+
+	if err := km.WriteRaw([]byte("history = 2\n[a]\ncommand = echo 2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, allowed := range []bool{false, true, false} {
+		km.SetSound(allowed)
+		if err := km.WriteSettings(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := km.Revisions(km.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldest, err := km.ReadRevision(km.Filename, revisions[len(revisions)-1].ID)
+	if err != nil || string(oldest) != "history = 2\n[a]\ncommand = echo 1\n" {
+		t.Fatalf("expected muting to leave the history alone, got %q: %v", oldest, err)
+	}
+
+	if raw := string(km.Raw()); !strings.Contains(raw, "sound") || !strings.Contains(raw, "off") {
+		t.Fatalf("expected the sound setting to be saved, got:\n%s", raw)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	km := keymapFromContent(t, "[a]\ncommand = echo 1\n")
+
+	if err := km.WriteRaw([]byte("[a]\ncommand = echo 2\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A hand edit that doesn't load is what rolling back is for.
+	if err := os.WriteFile(km.Filename, []byte("[a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Rollback(km.Filename, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(km.Raw()) != "[a]\ncommand = echo 2\n" {
+		t.Fatalf("unexpected config after rollback: %q", km.Raw())
+	}
+
+	// The rollback and the hand edit before it are saves of their own.
+	if _, err := Rollback(km.Filename, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(km.Raw()) != "[a]\ncommand = echo 1\n" {
+		t.Fatalf("unexpected config after second rollback: %q", km.Raw())
+	}
+
+	if _, err := Rollback(km.Filename, 10); !errors.Is(err, ErrNoRevision) {
+		t.Fatalf("expected ErrNoRevision, got %v", err)
+	}
+}
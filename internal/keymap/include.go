@@ -0,0 +1,214 @@
+package keymap
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+var ErrFileNotFound = errors.New("not one of the config files")
+
+// ConfDir is a directory of config files that are read after the main one,
+// named after it: keys.d for keys.ini.
+func ConfDir(filename string) string {
+	base := filepath.Base(filename)
+	return filepath.Join(filepath.Dir(filename), strings.TrimSuffix(base, filepath.Ext(base))+".d")
+}
+
+// includedFiles lists the files that a config pulls in, in the order they
+// are read: those matching each include option in the order written, then
+// the files of its conf dir by name, .ini first and then each other format
+// in turn. Each file is listed once.
+func includedFiles(filename string, content *ini.File) []string {
+	var patterns []string
+	if key, err := content.Section(ini.DefaultSection).GetKey("include"); err == nil {
+		for _, pattern := range key.ValueWithShadows() {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				patterns = append(patterns, includePath(filename, pattern))
+			}
+		}
+	}
+	for _, ext := range []string{".ini", ".json", ".toml", ".yaml", ".yml"} {
+		patterns = append(patterns, filepath.Join(ConfDir(filename), "*"+ext))
+	}
+
+	main, _ := filepath.Abs(filename)
+	var files []string
+
+	for _, pattern := range patterns {
+		// Glob only fails for malformed patterns, which keys check reports.
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			path, err := filepath.Abs(match)
+			if err != nil || path == main || slices.Contains(files, path) {
+				continue
+			}
+
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				files = append(files, path)
+			}
+		}
+	}
+
+	return files
+}
+
+// includePath resolves an include pattern against the directory of the
+// config that has it.
+func includePath(filename, pattern string) string {
+	if rest, found := strings.CutPrefix(pattern, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+
+	if filepath.IsAbs(pattern) {
+		return pattern
+	}
+
+	return filepath.Join(filepath.Dir(filename), pattern)
+}
+
+// merge adds the sections of an included file to the content. Global
+// options only count in the main file, and a section that is already
+// defined is ignored rather than merged into the earlier one.
+func (km *Keymap) merge(content *ini.File, path string) error {
+	included, err := km.parse(path, km.ReadFile(path))
+	if err != nil {
+		return fmt.Errorf("could not load %s: %w", path, err)
+	}
+
+	for _, source := range included.Sections() {
+		if source.Name() == ini.DefaultSection {
+			continue
+		}
+
+		if origin, found := km.origins[source.Name()]; found {
+			log.Printf("Ignoring [%s] in %s because it is already in %s", source.Name(), km.FileName(path), km.FileName(origin))
+			continue
+		}
+
+		section, err := content.NewSection(source.Name())
+		if err != nil {
+			return err
+		}
+
+		for _, key := range source.Keys() {
+			values := key.ValueWithShadows()
+			copied, err := section.NewKey(key.Name(), values[0])
+			if err != nil {
+				return err
+			}
+
+			for _, value := range values[1:] {
+				if err := copied.AddShadow(value); err != nil {
+					return err
+				}
+			}
+		}
+
+		km.origins[source.Name()] = path
+	}
+
+	return nil
+}
+
+// Files lists the main config file followed by the ones it includes.
+func (km *Keymap) Files() []string {
+	return append([]string{km.Filename}, km.included...)
+}
+
+// FileName is how a config file is shown and chosen in the editor: its
+// path relative to the main file's directory.
+func (km *Keymap) FileName(path string) string {
+	main, mainErr := filepath.Abs(km.Filename)
+	path, pathErr := filepath.Abs(path)
+	if mainErr != nil || pathErr != nil {
+		return path
+	}
+
+	if relative, err := filepath.Rel(filepath.Dir(main), path); err == nil {
+		return relative
+	}
+
+	return path
+}
+
+// File finds the config file with a name given by FileName. Only files
+// that are part of the config can be found, so that the editor can't be
+// used to reach others.
+func (km *Keymap) File(name string) (string, error) {
+	for _, path := range km.Files() {
+		if km.FileName(path) == name {
+			return path, nil
+		}
+	}
+
+	return "", ErrFileNotFound
+}
+
+// ReadFile returns the text of one of the config files.
+func (km *Keymap) ReadFile(path string) []byte {
+	if path == km.Filename {
+		return km.Raw()
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return []byte{}
+	}
+
+	return content
+}
+
+// CheckFile is Check for a config file and the files it includes, which are
+// named by their paths.
+func CheckFile(filename string) ([]Diagnostic, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []Source{{Name: filename, Raw: raw}}
+
+	// The includes of a config that doesn't load can't be known.
+	if content, err := parse(loadOptions, filename, raw); err == nil {
+		for _, path := range includedFiles(filename, content) {
+			included, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, Source{Name: path, Raw: included})
+		}
+	}
+
+	return CheckSources(sources), nil
+}
+
+// CheckEdit checks new content for one of the config files alongside the
+// others, and returns what was found in that file.
+func (km *Keymap) CheckEdit(path string, content []byte) []Diagnostic {
+	var sources []Source
+	for _, file := range km.Files() {
+		raw := content
+		if file != path {
+			raw = km.ReadFile(file)
+		}
+		sources = append(sources, Source{Name: km.FileName(file), Raw: raw})
+	}
+
+	var diagnostics []Diagnostic
+	for _, d := range CheckSources(sources) {
+		if d.File == km.FileName(path) {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,215 @@
+package keymap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// includeDir makes a directory beside the package for included files.
+func includeDir(t *testing.T) string {
+	t.Helper()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := os.MkdirTemp(cwd, "keys-test-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := includeDir(t)
+
+	included := filepath.Join(dir, "extra.ini")
+	content := "sound = off\n\n[extra]\nphysical_key = e\ncommand = echo extra\n\n[main]\ncommand = echo ignored\n"
+	if err := os.WriteFile(included, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	km := keymapFromContent(t, "include = "+filepath.Join(dir, "*.ini")+"\n\n[main]\nphysical_key = m\ncommand = echo main\n")
+
+	if key := km.FindKeyByName("extra"); key == nil || key.Commands[0] != "echo extra" {
+		t.Fatal("expected the included key to be loaded")
+	}
+
+	if key := km.FindKeyByName("main"); key == nil || key.Commands[0] != "echo main" {
+		t.Fatal("expected the main file to win over the included one")
+	}
+
+	if !km.SoundAllowed {
+		t.Fatal("expected global options in included files to be ignored")
+	}
+
+	files := km.Files()
+	if len(files) != 2 || files[1] != included {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	name := km.FileName(included)
+	if name != filepath.Join(filepath.Base(dir), "extra.ini") {
+		t.Fatalf("unexpected file name %s", name)
+	}
+
+	if path, err := km.File(name); err != nil || path != included {
+		t.Fatalf("expected to find %s, got %s: %v", included, path, err)
+	}
+
+	if _, err := km.File("../keymap.go"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("expected ErrFileNotFound, got %v", err)
+	}
+
+	sections := km.Sections()
+	if len(sections) != 2 || sections[1].Name != "extra" || sections[1].File != name {
+		t.Fatalf("unexpected sections %+v", sections)
+	}
+}
+
+func TestIncludeEdit(t *testing.T) {
+	dir := includeDir(t)
+
+	included := filepath.Join(dir, "extra.ini")
+	if err := os.WriteFile(included, []byte("[extra]\ncommand = echo extra\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	main := "include = " + included + "\n\n[main]\ncommand = echo main\n"
+	km := keymapFromContent(t, main)
+
+	if err := km.UpdateKey("extra", KeySection{Options: []Option{{"command", "echo changed"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := km.AddKey(KeySection{Name: "added", Options: []Option{{"command", "echo added"}}, File: km.FileName(included)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := km.AddKey(KeySection{Name: "extra", Options: []Option{{"command", "echo again"}}}); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	km.SetSound(false)
+	if err := km.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[extra]\ncommand = echo changed\n\n[added]\ncommand = echo added\n"
+	if got := string(km.ReadFile(included)); got != want {
+		t.Fatalf("expected included file:\n%s\ngot:\n%s", want, got)
+	}
+
+	raw := string(km.Raw())
+	if strings.Contains(raw, "[extra]") || strings.Contains(raw, "[added]") || !strings.Contains(raw, "sound") {
+		t.Fatalf("unexpected main file:\n%s", raw)
+	}
+
+	if err := km.DeleteKey("extra"); err != nil {
+		t.Fatal(err)
+	}
+
+	if km.FindKeyByName("extra") != nil {
+		t.Fatal("expected the included key to be deleted")
+	}
+
+	revisions, err := km.Revisions(included)
+	if err != nil || len(revisions) == 0 {
+		t.Fatalf("expected revisions of the included file: %v", err)
+	}
+}
+
+func TestConfDir(t *testing.T) {
+	km := keymapFromContent(t, "[main]\ncommand = echo main\n")
+
+	dir := ConfDir(km.Filename)
+	if filepath.Base(dir) != strings.TrimSuffix(filepath.Base(km.Filename), ".ini")+".d" {
+		t.Fatalf("unexpected conf dir %s", dir)
+	}
+
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for name, content := range map[string]string{
+		"b.ini":    "[b]\ncommand = echo b\n",
+		"a.ini":    "[a]\ncommand = echo a\n",
+		"notes.md": "[notes]\ncommand = echo notes\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := km.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := sectionNames(km)
+	if names != "main a b" {
+		t.Fatalf("unexpected sections %q", names)
+	}
+}
+
+func TestCheckSources(t *testing.T) {
+	diagnostics := CheckSources([]Source{
+		{Name: "keys.ini", Raw: []byte("include = keys.d/*.ini\n\n[a]\nphysical_key = a\ncommand = echo a\n")},
+		{Name: "keys.d/b.ini", Raw: []byte("sound = off\n\n[a]\ncommand = echo again\n\n[b]\nphysical_key = a\ncommand = echo b\n")},
+	})
+
+	want := []string{
+		"keys.d/b.ini:1: warning: Global options only work in the main config file, so sound is ignored",
+		"keys.d/b.ini:3: [a] Section is already in keys.ini, so this one is ignored",
+		"keys.d/b.ini:7: [b] Physical key \"a\" is already used by [a] in keys.ini",
+	}
+
+	if len(diagnostics) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %v", len(want), diagnostics)
+	}
+
+	for i, d := range diagnostics {
+		if got := d.File + ":" + d.String(); got != want[i] {
+			t.Errorf("expected %q, got %q", want[i], got)
+		}
+	}
+}
+
+func TestCheckFileJSON(t *testing.T) {
+	dir := includeDir(t)
+
+	included := filepath.Join(dir, "extra.ini")
+	if err := os.WriteFile(included, []byte("[extra]\nphysical_key = e\ncommand = echo extra\nrepeat = yes\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "keys.json")
+	content := `{"include": "` + filepath.Join(dir, "*.ini") + `", "keys": [{"name": "main", "physical_key": "m", "command": "echo main"}]}`
+	if err := os.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics, err := CheckFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diagnostics) != 1 || diagnostics[0].File != included {
+		t.Fatalf("expected a diagnostic for the included file, got %v", diagnostics)
+	}
+}
@@ -2,38 +2,115 @@ package keymap
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"keys/internal/output"
+	"keys/internal/schedule"
 	"log"
 	"math"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/ini.v1"
 )
 
+const confirmationWindow = 5 * time.Second
+
+var ErrTimeout = errors.New("timed out")
+
+// Modes accepted by the speech setting.
+var SpeechModes = []string{"off", "name", "state", "output"}
+
+// SpeechEngines can be chosen with speech_engine.
+var SpeechEngines = []string{"espeak-ng", "piper"}
+
+// Modes accepted by the notify setting.
+var NotifyModes = []string{"never", "error", "always"}
+
 type Key struct {
-	Name         string
-	PhysicalKey  string
-	Commands     []string
-	States       []string
-	CommandIndex uint8
-	ShowOutput   bool
-	Timeout      time.Duration
-	Confirmation bool
-	Row          string
+	Name               string
+	PhysicalKey        string
+	Commands           []string
+	States             []string
+	ShowOutput         bool
+	OutputFormat       string
+	Timeout            time.Duration
+	Confirmation       bool
+	Row                string
+	Probe              string
+	ProbeInterval      time.Duration
+	Schedule           string
+	ScheduleWhenLocked bool
+	RequireConfirm     bool
+	Cooldown           time.Duration
+	MaxConcurrent      int
+	Throttle           string
+	User               string
+	Nice               int
+	IONice             string
+	Limits             []string
+	Sandbox            []string
+	SandboxSources     []string
+	KillGrace          time.Duration
+	Detach             bool
+	Notify             string
+	Sound              string
+	SoundVolume        int
+	Speech             string
+	Params             []ParamSpec
+	Vars               map[string]string
+
+	// mu guards the state that changes as the key is triggered, since the
+	// same key can be triggered by several requests at once.
+	mu               sync.Mutex
+	commandIndex     uint8
+	confirmRequested time.Time
+}
+
+// option looks up a key without adding it to the section. Reading a missing
+// key with a default would otherwise write the default into the config file
+// the next time it is saved.
+func option(s *ini.Section, name string) *ini.Key {
+	if key, err := s.GetKey(name); err == nil {
+		return key
+	}
+
+	return ini.Empty().Section("").Key(name)
 }
 
 func NewKeyFromSection(s *ini.Section, row string) *Key {
 	k := &Key{
-		Name:         s.Name(),
-		PhysicalKey:  s.Key("physical_key").MustString(""),
-		Commands:     s.Key("command").ValueWithShadows(),
-		States:       s.Key("state").ValueWithShadows(),
-		CommandIndex: 0,
-		ShowOutput:   s.Key("output").MustBool(true),
-		Timeout:      time.Duration(s.Key("timeout").MustFloat64(10.0)) * time.Second,
-		Confirmation: s.Key("confirmation").MustBool(true),
-		Row:          row,
+		Name:               s.Name(),
+		PhysicalKey:        option(s, "physical_key").MustString(""),
+		Commands:           option(s, "command").ValueWithShadows(),
+		States:             option(s, "state").ValueWithShadows(),
+		ShowOutput:         option(s, "output").MustBool(true),
+		OutputFormat:       option(s, "output_format").In("text", output.Formats),
+		Timeout:            time.Duration(option(s, "timeout").MustFloat64(10.0) * float64(time.Second)),
+		Confirmation:       option(s, "confirmation").MustBool(true),
+		Row:                row,
+		Probe:              option(s, "probe").MustString(""),
+		ProbeInterval:      time.Duration(option(s, "probe_interval").MustFloat64(0) * float64(time.Second)),
+		Schedule:           option(s, "schedule").MustString(""),
+		ScheduleWhenLocked: option(s, "schedule_when_locked").MustBool(false),
+		RequireConfirm:     option(s, "require_confirm").MustBool(false),
+		Cooldown:           time.Duration(option(s, "cooldown").MustFloat64(0) * float64(time.Second)),
+		MaxConcurrent:      option(s, "max_concurrent").MustInt(0),
+		Throttle:           option(s, "throttle").In("reject", []string{"reject", "queue"}),
+		User:               option(s, "user").MustString(""),
+		Nice:               option(s, "nice").MustInt(0),
+		IONice:             option(s, "ionice").MustString(""),
+		Limits:             option(s, "limit").ValueWithShadows(),
+		Sandbox:            option(s, "sandbox").Strings(","),
+		SandboxSources:     option(s, "sandbox_sources").Strings(","),
+		KillGrace:          time.Duration(option(s, "kill_grace").MustFloat64(3.0) * float64(time.Second)),
+		Detach:             option(s, "detach").MustBool(false),
+		Notify:             option(s, "notify").In("", NotifyModes),
+		Sound:              option(s, "sound").MustString(""),
+		SoundVolume:        max(0, min(option(s, "sound_volume").MustInt(100), 100)),
+		Speech:             option(s, "speech").In("", SpeechModes),
 	}
 
 	if k.CurrentCommand() == "" {
@@ -44,6 +121,14 @@ func NewKeyFromSection(s *ini.Section, row string) *Key {
 		return nil
 	}
 
+	for _, declaration := range option(s, "param").ValueWithShadows() {
+		spec, err := ParseParam(declaration)
+		if err != nil {
+			return nil
+		}
+		k.Params = append(k.Params, spec)
+	}
+
 	return k
 }
 
@@ -51,11 +136,108 @@ func (k *Key) CanLock() bool {
 	return k.CurrentCommand() == "lock" || k.CurrentCommand() == "unlock"
 }
 
+func (k *Key) CanMute() bool {
+	return k.CurrentCommand() == "mute" || k.CurrentCommand() == "unmute"
+}
+
 func (k *Key) CanToggle() bool {
 	count := len(k.Commands)
 	return count > 1 && count < math.MaxUint8
 }
 
+// ShouldNotify reports whether a run should produce a desktop notification.
+// Keys that don't set a notify mode of their own use the global one.
+func (k *Key) ShouldNotify(globalMode string, failed bool) bool {
+	mode := k.Notify
+	if mode == "" {
+		mode = globalMode
+	}
+
+	switch mode {
+	case "always":
+		return true
+	case "error":
+		return failed
+	default:
+		return false
+	}
+}
+
+// SpokenFeedback returns what to say after the key runs, or nothing if
+// speech is off for the key. Keys that don't set a speech mode of their own
+// use the global one.
+func (k *Key) SpokenFeedback(globalMode string, stdout []byte, failed bool) string {
+	mode := k.Speech
+	if mode == "" {
+		mode = globalMode
+	}
+
+	if mode == "off" || mode == "" {
+		return ""
+	}
+
+	if failed {
+		return k.Name + " failed"
+	}
+
+	if mode == "output" {
+		line, _, _ := strings.Cut(strings.TrimSpace(string(stdout)), "\n")
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	if mode != "name" && k.CanToggle() {
+		return k.State()
+	}
+
+	return k.Name
+}
+
+func (k *Key) RequestConfirmation() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.confirmRequested = time.Now()
+}
+
+func (k *Key) AwaitingConfirmation() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.awaitingConfirmation()
+}
+
+func (k *Key) awaitingConfirmation() bool {
+	if k.confirmRequested.IsZero() {
+		return false
+	}
+
+	return time.Since(k.confirmRequested) < confirmationWindow
+}
+
+func (k *Key) ClearConfirmation() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.confirmRequested = time.Time{}
+}
+
+// Confirm reports whether confirmation was requested within the last few
+// seconds and clears the request if so. Otherwise it requests confirmation.
+func (k *Key) Confirm() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.awaitingConfirmation() {
+		k.confirmRequested = time.Time{}
+		return true
+	}
+
+	k.confirmRequested = time.Now()
+	return false
+}
+
 func (k *Key) MatchesCommand(command string) bool {
 	lcCommand := strings.ToLower(command)
 
@@ -81,7 +263,7 @@ func (k *Key) State() string {
 		return ""
 	}
 
-	return k.States[k.CommandIndex]
+	return k.States[k.CommandIndex()]
 }
 
 func (k *Key) LastCommand() string {
@@ -98,7 +280,22 @@ func (k *Key) CurrentCommand() string {
 		return ""
 	}
 
-	return k.Commands[k.CommandIndex]
+	return k.Commands[k.CommandIndex()]
+}
+
+// CommandIndex is the position of the current command, and of its state.
+func (k *Key) CommandIndex() uint8 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.commandIndex
+}
+
+func (k *Key) setCommandIndex(i int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.commandIndex = uint8(i)
 }
 
 func (k *Key) Toggle() {
@@ -106,23 +303,163 @@ func (k *Key) Toggle() {
 		return
 	}
 
-	if k.CurrentCommand() == k.LastCommand() {
-		k.CommandIndex = 0
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.Commands[k.commandIndex] == k.LastCommand() {
+		k.commandIndex = 0
 	} else {
-		k.CommandIndex += 1
+		k.commandIndex += 1
 	}
 }
 
 func (k *Key) RunCommand() ([]byte, error) {
+	return k.RunCommandContext(context.Background(), "", nil)
+}
+
+// RunCommandContext runs the current command. The source and params are
+// available to a command written as a template.
+func (k *Key) RunCommandContext(parent context.Context, source string, params map[string]string) ([]byte, error) {
+	if k.Detach {
+		return nil, k.launch(source, params)
+	}
+
 	log.Printf("Running command: %s", k.CurrentCommand())
 
-	ctx, cancel := context.WithTimeout(context.Background(), k.Timeout)
+	ctx, cancel := context.WithTimeout(parent, k.Timeout)
 	defer cancel()
 
-	// #nosec [204] [-- The command being run intentionally comes from a user-supplied value.]
-	cmd := exec.CommandContext(ctx, "sh", "-c", k.CurrentCommand())
+	cmd, err := k.command(ctx, source, params)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Toggle()
+
+	stdout, err := cmd.Output()
+
+	// Whatever the shell left behind in its process group goes with it.
+	if cmd.Process != nil {
+		_ = terminate(cmd.Process.Pid, k.KillGrace)
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		return stdout, fmt.Errorf("%s %w after %s", k.Name, ErrTimeout, k.Timeout)
+	}
+
+	// The shell exited normally but something it started kept stdout open.
+	if errors.Is(err, exec.ErrWaitDelay) {
+		return stdout, nil
+	}
+
+	return stdout, err
+}
+
+func (k *Key) launch(source string, params map[string]string) error {
+	log.Printf("Launching command: %s", k.CurrentCommand())
+
+	cmd, err := k.command(context.Background(), source, params)
+	if err != nil {
+		return err
+	}
+
+	// A new session keeps the command running independently of keys.
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
 
 	k.Toggle()
 
-	return cmd.Output()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		_ = cmd.Wait()
+	}()
+
+	return nil
+}
+
+func (k *Key) RunProbe() error {
+	return k.RunProbeContext(context.Background())
+}
+
+// RunProbeContext runs the probe of a toggle key and makes the state it
+// reports current. Output that names no state leaves the state alone unless
+// the exit code picks one.
+func (k *Key) RunProbeContext(parent context.Context) error {
+	if k.Probe == "" || !k.CanToggle() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(parent, k.Timeout)
+	defer cancel()
+
+	cmd, err := k.probeCommand(ctx)
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.Output()
+
+	if cmd.Process != nil {
+		_ = terminate(cmd.Process.Pid, k.KillGrace)
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("probe for %s %w", k.Name, ErrTimeout)
+	}
+
+	exitCode := 0
+	if err != nil && !errors.Is(err, exec.ErrWaitDelay) {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	result := strings.TrimSpace(string(stdout))
+	if result != "" {
+		for i, state := range k.States {
+			if strings.EqualFold(state, result) {
+				k.setCommandIndex(i)
+				return nil
+			}
+		}
+
+		if exitCode == 0 {
+			return fmt.Errorf("probe for %s returned unknown state %q, keeping %s", k.Name, result, k.State())
+		}
+	}
+
+	if exitCode >= 0 && exitCode < len(k.Commands) {
+		k.setCommandIndex(exitCode)
+		return nil
+	}
+
+	return fmt.Errorf("probe for %s returned unknown state %q (exit code %d)", k.Name, result, exitCode)
+}
+
+func (k *Key) NextRuns(count int) []time.Time {
+	if k.Schedule == "" {
+		return nil
+	}
+
+	parsed, err := schedule.Parse(k.Schedule)
+	if err != nil {
+		return nil
+	}
+
+	runs := make([]time.Time, 0, count)
+	next := time.Now()
+	for range count {
+		next = parsed.Next(next)
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+	}
+
+	return runs
 }
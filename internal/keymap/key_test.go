@@ -1,11 +1,13 @@
 package keymap
 
 import (
+	"errors"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -71,7 +73,7 @@ func TestCommandRequired(t *testing.T) {
 func TestToggle(t *testing.T) {
 	key := loadKeyFromFixture(t, "key-roll.ini")
 
-	if key.CommandIndex != 0 {
+	if key.CommandIndex() != 0 {
 		t.Fatal("Command index did not start at zero")
 	}
 
@@ -198,6 +200,10 @@ func TestCommandTimeout(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Command did not time out")
 	}
+
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Timeout was not reported as such: %v", err)
+	}
 }
 
 func TestCommand(t *testing.T) {
@@ -246,3 +252,167 @@ func TestCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		fixture string
+		state   string
+		valid   bool
+	}{
+		{fixture: "key-probe.ini", state: "state2", valid: true},
+		{fixture: "key-probe-exit.ini", state: "state3", valid: true},
+		{fixture: "key-probe-invalid.ini", state: "state1", valid: false},
+		{fixture: "key-roll.ini", state: "state1", valid: true},
+	}
+
+	for _, tt := range tests {
+		key := loadKeyFromFixture(t, tt.fixture)
+
+		err := key.RunProbe()
+		if tt.valid && err != nil {
+			t.Errorf("Probe for %s failed: %v", tt.fixture, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("Probe for %s should have failed", tt.fixture)
+		}
+
+		if key.State() != tt.state {
+			t.Errorf("Expected state %s for %s, got %s", tt.state, tt.fixture, key.State())
+		}
+	}
+}
+
+func TestProbeKeepsState(t *testing.T) {
+	km := keymapFromContent(t, "[lamp]\ncommand = echo on\ncommand = echo off\nstate = on\nstate = off\nprobe = echo dimmed\n")
+	key := km.FindKey("lamp")
+	key.Toggle()
+
+	if err := key.RunProbe(); err == nil {
+		t.Error("expected a probe that names no state to fail")
+	}
+
+	if key.State() != "off" {
+		t.Errorf("expected the state to be kept, got %s", key.State())
+	}
+
+	km = keymapFromContent(t, "[lamp]\ncommand = echo on\ncommand = echo off\nstate = on\nstate = off\nprobe = echo on\nuser = no-such-user-for-keys\n")
+	if err := km.FindKey("lamp").RunProbe(); err == nil {
+		t.Error("expected the probe to be run as the user of the key")
+	}
+}
+
+func TestProbedKeys(t *testing.T) {
+
+	content := ""
+	for _, name := range []string{"a", "b", "c", "d"} {
+		content += "[" + name + "]\ncommand = echo on\ncommand = echo off\nstate = on\nstate = off\nprobe = sleep 1 && echo off\n\n"
+	}
+	km := keymapFromContent(t, content)
+
+	started := time.Now()
+	for key := range km.ProbedKeys() {
+		if key.State() != "off" {
+			t.Errorf("expected %s to be probed, got %s", key.Name, key.State())
+		}
+	}
+
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("expected the probes to run at the same time, took %s", elapsed)
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	tests := []struct {
+		fixture string
+		count   int
+	}{
+		{fixture: "key-schedule.ini", count: 3},
+		{fixture: "key-single.ini", count: 0},
+	}
+
+	for _, tt := range tests {
+		key := loadKeyFromFixture(t, tt.fixture)
+
+		runs := key.NextRuns(3)
+		if len(runs) != tt.count {
+			t.Fatalf("Expected %d runs for %s, got %d", tt.count, tt.fixture, len(runs))
+		}
+
+		for i := 1; i < len(runs); i++ {
+			if runs[i].Sub(runs[i-1]) != time.Hour {
+				t.Errorf("Unexpected gap between runs %d and %d", i-1, i)
+			}
+		}
+	}
+}
+
+func TestScheduledKeys(t *testing.T) {
+	km := keymapFromFixture(t, "key-schedule.ini")
+
+	jobs := km.ScheduledKeys()
+	if len(jobs) != 2 || jobs[0].Name != "test" || jobs[0].Spec != "every 1h" {
+		t.Fatalf("unexpected jobs %+v", jobs)
+	}
+
+	if km.FindKeyByName("test").ScheduleWhenLocked {
+		t.Error("expected scheduled runs to wait while the keyboard is locked by default")
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	tests := []struct {
+		notify string
+		global string
+		failed bool
+		want   bool
+	}{
+		{notify: "", global: "never", failed: true, want: false},
+		{notify: "", global: "error", failed: true, want: true},
+		{notify: "", global: "error", failed: false, want: false},
+		{notify: "always", global: "never", failed: false, want: true},
+		{notify: "never", global: "always", failed: true, want: false},
+		{notify: "error", global: "always", failed: false, want: false},
+	}
+
+	key := loadKeyFromFixture(t, "key-single.ini")
+
+	for _, tt := range tests {
+		key.Notify = tt.notify
+		if got := key.ShouldNotify(tt.global, tt.failed); got != tt.want {
+			t.Errorf("notify=%q global=%q failed=%v: expected %v, got %v", tt.notify, tt.global, tt.failed, tt.want, got)
+		}
+	}
+}
+
+func TestSpokenFeedback(t *testing.T) {
+	tests := []struct {
+		fixture string
+		speech  string
+		global  string
+		stdout  string
+		failed  bool
+		want    string
+	}{
+		{fixture: "key-single.ini", global: "off", want: ""},
+		{fixture: "key-single.ini", global: "name", stdout: "hello", want: "test"},
+		{fixture: "key-single.ini", global: "state", want: "test"},
+		{fixture: "key-roll.ini", global: "state", want: "state1"},
+		{fixture: "key-roll.ini", global: "name", want: "test"},
+		{fixture: "key-single.ini", global: "output", stdout: "\n first line \nsecond", want: "first line"},
+		{fixture: "key-roll.ini", global: "output", want: "state1"},
+		{fixture: "key-single.ini", speech: "off", global: "name", want: ""},
+		{fixture: "key-single.ini", speech: "name", global: "off", want: "test"},
+		{fixture: "key-single.ini", global: "output", failed: true, want: "test failed"},
+	}
+
+	for _, tt := range tests {
+		key := loadKeyFromFixture(t, tt.fixture)
+		key.Speech = tt.speech
+
+		got := key.SpokenFeedback(tt.global, []byte(tt.stdout), tt.failed)
+		if got != tt.want {
+			t.Errorf("%s with speech=%q global=%q: expected %q, got %q", tt.fixture, tt.speech, tt.global, tt.want, got)
+		}
+	}
+}
@@ -1,15 +1,38 @@
 package keymap
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"keys/internal/asset"
+	"keys/internal/schedule"
+	"log"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
 
-var keyCache = make(map[string]*Key)
+var (
+	keyCache   = make(map[string]*Key)
+	keyCacheMu sync.Mutex
+)
+
+var ErrStale = errors.New("the config file has changed since it was read")
+
+// probeDeadline is how long ProbedKeys waits for probes.
+const probeDeadline = 3 * time.Second
+
+var loadOptions = ini.LoadOptions{
+	SkipUnrecognizableLines: true,
+	AllowShadows:            true,
+}
 
 type Keymap struct {
 	Filename           string
@@ -17,6 +40,24 @@ type Keymap struct {
 	LoadOptions        ini.LoadOptions
 	SoundAllowed       bool
 	DesignatedKeyboard string
+	MaxConcurrent      int
+	Notify             string
+	Speech             string
+	History            int
+	Vars               map[string]string
+
+	// included are the files merged into Content after the main one, and
+	// origins says which file each section came from.
+	included []string
+	origins  map[string]string
+
+	// OnLoad is called each time the config has been loaded again, such as
+	// after an edit.
+	OnLoad func()
+
+	// mu is held while the config file is read, changed and written back,
+	// so that edits from different requests don't overwrite each other.
+	mu sync.Mutex
 }
 
 func Translate(codeName string) string {
@@ -27,11 +68,8 @@ func Translate(codeName string) string {
 
 func NewKeymap(filename string) (*Keymap, error) {
 	km := Keymap{
-		Filename: filename,
-		LoadOptions: ini.LoadOptions{
-			SkipUnrecognizableLines: true,
-			AllowShadows:            true,
-		},
+		Filename:    filename,
+		LoadOptions: loadOptions,
 	}
 
 	err := km.Load()
@@ -43,40 +81,120 @@ func NewKeymap(filename string) (*Keymap, error) {
 }
 
 func (km *Keymap) Load() error {
+	keyCacheMu.Lock()
 	clear(keyCache)
+	keyCacheMu.Unlock()
 
-	content, err := ini.LoadSources(km.LoadOptions, km.Raw())
+	content, err := km.parse(km.Filename, km.Raw())
 	if err != nil {
 		return err
 	}
 
+	km.origins = make(map[string]string)
+	for _, section := range content.Sections() {
+		km.origins[section.Name()] = km.Filename
+	}
+
+	km.included = includedFiles(km.Filename, content)
+	for _, path := range km.included {
+		if err := km.merge(content, path); err != nil {
+			return err
+		}
+	}
+
 	km.Content = content
 	km.Content.BlockMode = false
 	km.SoundAllowed = km.defaultSectionKey("sound").MustBool(true)
 	km.DesignatedKeyboard = km.defaultSectionKey("keyboard").String()
+	km.MaxConcurrent = km.defaultSectionKey("max_concurrent").MustInt(10)
+	km.Notify = km.defaultSectionKey("notify").In("never", NotifyModes)
+	km.History = km.defaultSectionKey("history").MustInt(DefaultHistory)
+	km.Vars = readVars(content)
+
+	km.Speech = km.defaultSectionKey("speech").In("off", SpeechModes)
+
+	km.reportProblems()
+
+	if km.OnLoad != nil {
+		km.OnLoad()
+	}
 
 	return nil
 }
 
+// reportProblems logs the mistakes that check would find, since a key with
+// one is left out of the keymap rather than stopping it from loading.
+func (km *Keymap) reportProblems() {
+	var sources []Source
+	for _, file := range km.Files() {
+		sources = append(sources, Source{Name: km.FileName(file), Raw: km.ReadFile(file)})
+	}
+
+	for _, d := range CheckSources(sources) {
+		if d.Warning {
+			continue
+		}
+
+		if d.Line > 0 {
+			log.Printf("%s:%s", d.File, d)
+		} else {
+			log.Printf("%s: %s", d.File, d)
+		}
+	}
+}
+
 func (km *Keymap) Replace(newContent []byte) error {
-	content, err := ini.LoadSources(km.LoadOptions, newContent)
-	if err != nil {
-		return err
+	return km.WriteRaw(newContent)
+}
+
+// ReplaceIfUnchanged replaces one of the config files with an edit of it as
+// it was when its hash was taken, and fails with ErrStale if it has changed
+// since. An empty hash skips the check.
+func (km *Keymap) ReplaceIfUnchanged(path string, hash string, newContent []byte) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if hash != "" && hash != Hash(km.ReadFile(path)) {
+		return ErrStale
 	}
 
-	km.Content = content
+	return km.WriteRawFile(path, newContent)
+}
 
-	err = km.Write()
-	if err != nil {
-		return err
+// parse loads the text of a config file in whatever format it is.
+func (km *Keymap) parse(path string, raw []byte) (*ini.File, error) {
+	return parse(km.LoadOptions, path, raw)
+}
+
+func parse(options ini.LoadOptions, path string, raw []byte) (*ini.File, error) {
+	if !isINI(path) {
+		var err error
+		if raw, err = toINI(path, raw); err != nil {
+			return nil, err
+		}
 	}
 
-	return km.Load()
+	return ini.LoadSources(options, raw)
+}
+
+// Hash identifies a version of the config file.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
 }
 
 func (km *Keymap) Raw() []byte {
 	if _, statErr := os.Stat(km.Filename); os.IsNotExist(statErr) {
-		return asset.ReadKeymapSkeleton()
+		skeleton := asset.ReadKeymapSkeleton()
+		if isINI(km.Filename) {
+			return skeleton
+		}
+
+		converted, err := fromINI(km.Filename, skeleton)
+		if err != nil {
+			return []byte{}
+		}
+		return converted
 	}
 
 	bytes, err := os.ReadFile(km.Filename)
@@ -87,6 +205,9 @@ func (km *Keymap) Raw() []byte {
 }
 
 func (km *Keymap) FindKey(target string) *Key {
+	keyCacheMu.Lock()
+	defer keyCacheMu.Unlock()
+
 	if key, found := keyCache[target]; found {
 		return key
 	}
@@ -96,6 +217,16 @@ func (km *Keymap) FindKey(target string) *Key {
 		key = km.findKeyByPhysicalKey(target)
 	}
 
+	// Lookups by name and by physical key share one instance so that
+	// runtime state such as the current command stays in sync.
+	if key != nil {
+		if cached, found := keyCache[key.Name]; found && cached != nil {
+			key = cached
+		} else {
+			keyCache[key.Name] = key
+		}
+	}
+
 	keyCache[target] = key
 	return key
 }
@@ -106,7 +237,23 @@ func (km *Keymap) FindKeyByName(name string) *Key {
 		return nil
 	}
 
-	return NewKeyFromSection(section, "")
+	return km.newKey(section, "")
+}
+
+// newKey is NewKeyFromSection for a section of this keymap, which gives the
+// key the vars its command can use. The vars and layout sections aren't
+// keys.
+func (km *Keymap) newKey(s *ini.Section, row string) *Key {
+	if s.Name() == VarsSection || s.Name() == LayoutSection {
+		return nil
+	}
+
+	key := NewKeyFromSection(s, row)
+	if key != nil {
+		key.Vars = km.Vars
+	}
+
+	return key
 }
 
 func (km *Keymap) findKeyByPhysicalKey(physicalKey string) *Key {
@@ -118,7 +265,7 @@ func (km *Keymap) findKeyByPhysicalKey(physicalKey string) *Key {
 			continue
 		}
 		if iniKey.Value() == wanted {
-			return NewKeyFromSection(section, "")
+			return km.newKey(section, "")
 		}
 	}
 
@@ -127,7 +274,7 @@ func (km *Keymap) findKeyByPhysicalKey(physicalKey string) *Key {
 
 func (km *Keymap) IsPhysicalKeyPrefix(prefix string) bool {
 	for _, section := range km.Content.Sections() {
-		physicalKey := section.Key("physical_key").MustString("")
+		physicalKey := option(section, "physical_key").MustString("")
 		if strings.HasPrefix(physicalKey, prefix) && len(prefix) < len(physicalKey) {
 			return true
 		}
@@ -149,7 +296,7 @@ func (km *Keymap) Keys() func(yield func(*Key) bool) {
 				continue
 			}
 
-			key := NewKeyFromSection(s, row)
+			key := km.newKey(s, row)
 
 			if key == nil {
 				continue
@@ -162,11 +309,167 @@ func (km *Keymap) Keys() func(yield func(*Key) bool) {
 	}
 }
 
+// ScheduledKeys are the keys with a schedule option, for the scheduler.
+func (km *Keymap) ScheduledKeys() []schedule.Job {
+	var jobs []schedule.Job
+	for key := range km.Keys() {
+		if key.Schedule != "" {
+			jobs = append(jobs, schedule.Job{Name: key.Name, Spec: key.Schedule})
+		}
+	}
+
+	return jobs
+}
+
+// ProbedKeys is Keys with the probes of toggle keys run first, all at once
+// and within probeDeadline, so that the states shown are current.
+func (km *Keymap) ProbedKeys() func(yield func(*Key) bool) {
+	return func(yield func(*Key) bool) {
+		keys := slices.Collect(km.Keys())
+
+		ctx, cancel := context.WithTimeout(context.Background(), probeDeadline)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			if key.Probe == "" {
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if err := key.RunProbeContext(ctx); err != nil {
+					log.Println(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for _, key := range keys {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
 func (km *Keymap) SetKeyboard(path string) {
 	km.Content.Section(ini.DefaultSection).Key("keyboard").SetValue(path)
 }
 
+func (km *Keymap) SetSound(allowed bool) {
+	value := "off"
+	if allowed {
+		value = "on"
+	}
+
+	km.Content.Section(ini.DefaultSection).Key("sound").SetValue(value)
+	km.SoundAllowed = allowed
+}
+
+// Write saves the global options, such as those changed by SetKeyboard,
+// SetSound and SetLayout, to the main config file. Keys are left as they
+// are in the file, since the loaded content also has the keys from included
+// files.
 func (km *Keymap) Write() error {
+	return km.writeOptions(km.save)
+}
+
+// WriteSettings is Write for settings flipped while keys runs, such as
+// muting and the layout the keymap page draws. It keeps no revision, so
+// that flipping them doesn't push edits out of the history.
+func (km *Keymap) WriteSettings() error {
+	return km.writeOptions(replaceFile)
+}
+
+func (km *Keymap) writeOptions(save func(filename string, write func(path string) error) error) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	main, err := km.parse(km.Filename, km.Raw())
+	if err != nil {
+		return err
+	}
+
+	// Options cleared from the keymap, as by SetLayout, go from the file too.
+	defaults := main.Section(ini.DefaultSection)
+	for _, name := range defaults.KeyStrings() {
+		if !km.Content.Section(ini.DefaultSection).HasKey(name) {
+			defaults.DeleteKey(name)
+		}
+	}
+
+	for _, key := range km.Content.Section(ini.DefaultSection).Keys() {
+		defaults.DeleteKey(key.Name())
+
+		values := key.ValueWithShadows()
+		copied, err := defaults.NewKey(key.Name(), values[0])
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values[1:] {
+			if err := copied.AddShadow(value); err != nil {
+				return err
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if _, err := main.WriteTo(&out); err != nil {
+		return err
+	}
+
+	raw := out.Bytes()
+	if !isINI(km.Filename) {
+		if raw, err = fromINI(km.Filename, raw); err != nil {
+			return err
+		}
+	}
+
+	return save(km.Filename, func(path string) error {
+		return os.WriteFile(path, raw, 0600)
+	})
+}
+
+// WriteRaw saves content to the main config file exactly as given, rather
+// than as formatted by the ini library, and reloads it.
+func (km *Keymap) WriteRaw(content []byte) error {
+	return km.WriteRawFile(km.Filename, content)
+}
+
+// WriteRawFile is WriteRaw for any of the config files.
+func (km *Keymap) WriteRawFile(path string, content []byte) error {
+	if _, err := km.parse(path, content); err != nil {
+		return err
+	}
+
+	err := km.save(path, func(temp string) error {
+		return os.WriteFile(temp, content, 0600)
+	})
+	if err != nil {
+		return err
+	}
+
+	return km.Load()
+}
+
+// save replaces a config file with what write puts in a temp file, and
+// keeps a revision of it.
+func (km *Keymap) save(filename string, write func(path string) error) error {
+	before, err := os.ReadFile(filename)
+	if err != nil {
+		before = nil
+	}
+
+	return km.keepAround(filename, before, func() error {
+		return replaceFile(filename, write)
+	})
+}
+
+func replaceFile(filename string, write func(path string) error) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -178,6 +481,7 @@ func (km *Keymap) Write() error {
 	if err != nil {
 		return err
 	}
+	tempFile.Close()
 	defer func() {
 		removeErr := os.Remove(tempFile.Name())
 		if removeErr != nil {
@@ -185,18 +489,29 @@ func (km *Keymap) Write() error {
 		}
 	}()
 
-	err = km.Content.SaveTo(tempFile.Name())
+	err = write(tempFile.Name())
 	if err != nil {
 		return fmt.Errorf("could not write keymap to temp file: %w", err)
 	}
 
-	if err := os.Rename(tempFile.Name(), km.Filename); err != nil {
+	if err := os.Rename(tempFile.Name(), filename); err != nil {
 		return fmt.Errorf("could not rename keymap temp file: %w", err)
 	}
 
 	return nil
 }
 
+// Volume reads a global volume option, which is a percentage from 0 to
+// 100.
+func (km *Keymap) Volume(name string) int {
+	return max(0, min(km.defaultSectionKey(name).MustInt(100), 100))
+}
+
+// Option reads a global option. Options that aren't set read as empty.
+func (km *Keymap) Option(name string) *ini.Key {
+	return km.defaultSectionKey(name)
+}
+
 func (km *Keymap) defaultSectionKey(key string) *ini.Key {
-	return km.Content.Section(ini.DefaultSection).Key(key)
+	return option(km.Content.Section(ini.DefaultSection), key)
 }
@@ -6,6 +6,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -124,6 +125,21 @@ func TestSetKeyboard(t *testing.T) {
 	}
 }
 
+func TestSetSound(t *testing.T) {
+	t.Cleanup(clearCache)
+	km := keymapFromFixture(t, "sound-on.ini")
+
+	km.SetSound(false)
+
+	if km.SoundAllowed {
+		t.Fatal("SoundAllowed was not updated")
+	}
+
+	if km.Content.Section(ini.DefaultSection).Key("sound").String() != "off" {
+		t.Fatal("Sound setting not found in default section after being set")
+	}
+}
+
 func TestSave(t *testing.T) {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -140,6 +156,10 @@ func TestSave(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+
+		if err := os.RemoveAll(HistoryDir(tempFile.Name())); err != nil {
+			t.Fatal(err)
+		}
 	})
 
 	km := keymapFromFixture(t, "key-multiple.ini")
@@ -164,6 +184,35 @@ func TestSave(t *testing.T) {
 	}
 }
 
+func TestWriteWaitsForEdits(t *testing.T) {
+	km := keymapFromContent(t, "[a]\ncommand = echo 1\n")
+	km.SetSound(false)
+
+	// An editor save holds the lock while it checks and writes the file.
+	km.mu.Lock()
+	done := make(chan error)
+	go func() { done <- km.Write() }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Write did not wait for the edit in progress: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := km.WriteRawFile(km.Filename, []byte("[a]\ncommand = echo 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	km.mu.Unlock()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if raw := string(km.Raw()); !strings.Contains(raw, "echo 2") {
+		t.Fatalf("expected the edit to be kept, got:\n%s", raw)
+	}
+}
+
 func TestSoundAllowed(t *testing.T) {
 	tests := []struct {
 		fixture string
@@ -0,0 +1,225 @@
+package keymap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// LayoutSection describes where the keys of the device are, one row per
+// row option. It is not a key.
+const LayoutSection = "layout"
+
+// LayoutKey is a key of the device, placed in keys from the top left.
+type LayoutKey struct {
+	PhysicalKey string  `json:"physical_key"`
+	X           float64 `json:"x"`
+	Y           float64 `json:"y"`
+	Width       float64 `json:"width"`
+	Height      float64 `json:"height"`
+}
+
+// Layout is where the keys of a device sit, for drawing it.
+type Layout struct {
+	Name string
+	Keys []LayoutKey
+}
+
+// builtinLayouts are written as a layout section would be.
+var builtinLayouts = []struct {
+	name string
+	rows []string
+}{
+	{"numpad", []string{
+		"numlock kpslash kpasterisk kpminus",
+		"kp7 kp8 kp9 kpplus:1x2",
+		"kp4 kp5 kp6",
+		"kp1 kp2 kp3 kpenter:1x2",
+		"kp0:2 kpdot",
+	}},
+	{"3x3", []string{
+		"1 2 3",
+		"q w e",
+		"a s d",
+	}},
+	{"4x4", []string{
+		"1 2 3 4",
+		"q w e r",
+		"a s d f",
+		"z x c v",
+	}},
+	{"full", []string{
+		"esc _ f1 f2 f3 f4 _:0.5 f5 f6 f7 f8 _:0.5 f9 f10 f11 f12 _:0.25 sysrq scrolllock pause",
+		"grave 1 2 3 4 5 6 7 8 9 0 minus equal backspace:2 _:0.25 insert home pageup _:0.25 numlock kpslash kpasterisk kpminus",
+		"tab:1.5 q w e r t y u i o p leftbrace rightbrace backslash:1.5 _:0.25 delete end pagedown _:0.25 kp7 kp8 kp9 kpplus:1x2",
+		"capslock:1.75 a s d f g h j k l semicolon apostrophe enter:2.25 _:3.5 kp4 kp5 kp6",
+		"leftshift:2.25 z x c v b n m comma dot slash rightshift:2.75 _:1.25 up _:1.25 kp1 kp2 kp3 kpenter:1x2",
+		"leftctrl:1.25 leftmeta:1.25 leftalt:1.25 space:6.25 rightalt:1.25 rightmeta:1.25 compose:1.25 rightctrl:1.25 _:0.25 left down right _:0.25 kp0:2 kpdot",
+	}},
+}
+
+// LayoutNames are the built-in layouts that the layout option can choose.
+var LayoutNames = func() []string {
+	var names []string
+	for _, layout := range builtinLayouts {
+		names = append(names, layout.name)
+	}
+	return names
+}()
+
+// BuiltinLayout returns one of the LayoutNames.
+func BuiltinLayout(name string) (Layout, bool) {
+	for _, builtin := range builtinLayouts {
+		if builtin.name == name {
+			// The built-in layouts are known to parse.
+			layout, _ := ParseLayout(builtin.rows)
+			layout.Name = name
+			return layout, true
+		}
+	}
+
+	return Layout{}, false
+}
+
+// ParseLayout reads the rows of a layout section. Each row lists physical
+// keys from left to right, with a width such as enter:2.25 or a width and
+// height such as kpplus:1x2 for keys that aren't 1 by 1. An underscore is a
+// gap, such as _:0.5. Keys taller than a row push the keys of the rows
+// below them along.
+func ParseLayout(rows []string) (Layout, error) {
+	var layout Layout
+
+	for y, row := range rows {
+		x := 0.0
+
+		for _, field := range strings.Fields(row) {
+			name, size, _ := strings.Cut(field, ":")
+			width, height, err := parseKeySize(size)
+			if err != nil {
+				return Layout{}, fmt.Errorf("row %d: %s: %w", y+1, field, err)
+			}
+
+			x = layout.skipTall(x, float64(y))
+
+			if name != "_" {
+				if slices.ContainsFunc(layout.Keys, func(k LayoutKey) bool { return k.PhysicalKey == name }) {
+					return Layout{}, fmt.Errorf("row %d: %s is already in the layout", y+1, name)
+				}
+
+				layout.Keys = append(layout.Keys, LayoutKey{name, x, float64(y), width, height})
+			}
+
+			x += width
+		}
+	}
+
+	if len(layout.Keys) == 0 {
+		return Layout{}, errors.New("has no keys")
+	}
+
+	return layout, nil
+}
+
+func parseKeySize(size string) (float64, float64, error) {
+	if size == "" {
+		return 1, 1, nil
+	}
+
+	widthText, heightText, hasHeight := strings.Cut(size, "x")
+
+	width, err := strconv.ParseFloat(widthText, 64)
+	if err != nil || width <= 0 {
+		return 0, 0, errors.New("width must be a number of keys, such as 1.5")
+	}
+
+	height := 1.0
+	if hasHeight {
+		height, err = strconv.ParseFloat(heightText, 64)
+		if err != nil || height <= 0 {
+			return 0, 0, errors.New("height must be a number of keys, such as 2")
+		}
+	}
+
+	return width, height, nil
+}
+
+// skipTall moves x past any key from a row above that reaches down into
+// this row there.
+func (l Layout) skipTall(x, y float64) float64 {
+	for moved := true; moved; {
+		moved = false
+		for _, k := range l.Keys {
+			if k.Y < y && k.Y+k.Height > y && k.X <= x && x < k.X+k.Width {
+				x = k.X + k.Width
+				moved = true
+			}
+		}
+	}
+
+	return x
+}
+
+// Size is the width and height of the layout in keys.
+func (l Layout) Size() (float64, float64) {
+	var width, height float64
+	for _, k := range l.Keys {
+		width = max(width, k.X+k.Width)
+		height = max(height, k.Y+k.Height)
+	}
+
+	return width, height
+}
+
+// Layout is the layout section of the config if it has one, or else the
+// built-in layout chosen by the layout option. It has no keys if there is
+// neither or the layout section has mistakes, which check reports.
+func (km *Keymap) Layout() Layout {
+	if section, err := km.Content.GetSection(LayoutSection); err == nil {
+		layout, _ := ParseLayout(option(section, "row").ValueWithShadows())
+		layout.Name = LayoutSection
+		return layout
+	}
+
+	layout, _ := BuiltinLayout(km.defaultSectionKey("layout").String())
+	return layout
+}
+
+// SetLayout chooses a built-in layout, or none if name is empty. A layout
+// section still takes precedence.
+func (km *Keymap) SetLayout(name string) error {
+	if _, found := BuiltinLayout(name); !found && name != "" {
+		return fmt.Errorf("layout must be one of %s", strings.Join(LayoutNames, ", "))
+	}
+
+	defaults := km.Content.Section(ini.DefaultSection)
+	if name == "" {
+		defaults.DeleteKey("layout")
+	} else {
+		defaults.Key("layout").SetValue(name)
+	}
+
+	return nil
+}
+
+// PlacedKey is a key of the layout and the key of the keymap it triggers,
+// if any.
+type PlacedKey struct {
+	LayoutKey
+	Key *Key
+}
+
+// PlacedKeys matches the keys of the layout with the keymap. Keys of the
+// keymap whose physical key is a sequence, such as hi, have no place.
+func (km *Keymap) PlacedKeys() []PlacedKey {
+	layout := km.Layout()
+	placed := make([]PlacedKey, len(layout.Keys))
+	for i, k := range layout.Keys {
+		placed[i] = PlacedKey{LayoutKey: k, Key: km.findKeyByPhysicalKey(k.PhysicalKey)}
+	}
+
+	return placed
+}
@@ -0,0 +1,152 @@
+package keymap
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/holoplot/go-evdev"
+)
+
+func TestParseLayout(t *testing.T) {
+	layout, found := BuiltinLayout("numpad")
+	if !found {
+		t.Fatal("numpad layout not found")
+	}
+
+	want := map[string]LayoutKey{
+		"kpplus":  {"kpplus", 3, 1, 1, 2},
+		"kp6":     {"kp6", 2, 2, 1, 1},
+		"kpenter": {"kpenter", 3, 3, 1, 2},
+		"kp0":     {"kp0", 0, 4, 2, 1},
+		"kpdot":   {"kpdot", 2, 4, 1, 1},
+	}
+	for _, k := range layout.Keys {
+		if w, ok := want[k.PhysicalKey]; ok && k != w {
+			t.Errorf("expected %+v, got %+v", w, k)
+		}
+	}
+
+	if width, height := layout.Size(); width != 4 || height != 5 {
+		t.Errorf("expected a 4 by 5 numpad, got %v by %v", width, height)
+	}
+
+	for rows, want := range map[string]string{
+		"":                "has no keys",
+		"_ _:2":           "has no keys",
+		"a b:wide":        "row 1: b:wide: width must be a number of keys, such as 1.5",
+		"a b:1x0":         "row 1: b:1x0: height must be a number of keys, such as 2",
+		"a b\nc a":        "row 2: a is already in the layout",
+		"a b:-1":          "row 1: b:-1: width must be a number of keys, such as 1.5",
+		"a:1.5 b\nc:1x2d": "row 2: c:1x2d: height must be a number of keys, such as 2",
+	} {
+		if _, err := ParseLayout(strings.Split(rows, "\n")); err == nil || err.Error() != want {
+			t.Errorf("expected %q for %q, got %v", want, rows, err)
+		}
+	}
+}
+
+func TestBuiltinLayouts(t *testing.T) {
+	for _, name := range LayoutNames {
+		layout, found := BuiltinLayout(name)
+		if !found || len(layout.Keys) == 0 {
+			t.Fatalf("%s layout not found", name)
+		}
+
+		for _, k := range layout.Keys {
+			if _, known := evdev.KEYFromString["KEY_"+strings.ToUpper(k.PhysicalKey)]; !known {
+				t.Errorf("%s: %s is not a key", name, k.PhysicalKey)
+			}
+		}
+	}
+
+	full, _ := BuiltinLayout("full")
+	if len(full.Keys) != 104 {
+		t.Errorf("expected 104 keys, got %d", len(full.Keys))
+	}
+}
+
+func TestPlacedKeys(t *testing.T) {
+	km := keymapFromContent(t, "layout = 4x4\n\n[lights]\nphysical_key = q\ncommand = echo lights\n")
+
+	placed := km.PlacedKeys()
+	if len(placed) != 16 {
+		t.Fatalf("expected 16 keys, got %d", len(placed))
+	}
+
+	for _, p := range placed {
+		if (p.Key != nil) != (p.PhysicalKey == "q") {
+			t.Errorf("unexpected key %+v for %s", p.Key, p.PhysicalKey)
+		}
+	}
+
+	km = keymapFromContent(t, "layout = 4x4\n\n[layout]\nrow = q w\nrow = _ a:2\n\n[lights]\nphysical_key = a\ncommand = echo lights\n")
+
+	if layout := km.Layout(); layout.Name != LayoutSection || len(layout.Keys) != 3 || layout.Keys[2] != (LayoutKey{"a", 1, 1, 2, 1}) {
+		t.Fatalf("expected the layout section to win, got %+v", layout)
+	}
+
+	if names := sectionNames(km); names != "layout lights" {
+		t.Errorf("expected the layout section to be kept, got %v", names)
+	}
+
+	for key := range km.Keys() {
+		if key.Name != "lights" {
+			t.Errorf("unexpected key %s", key.Name)
+		}
+	}
+}
+
+func TestSetLayout(t *testing.T) {
+	km := keymapFromContent(t, "[lights]\nphysical_key = q\ncommand = echo lights\n")
+
+	if err := km.SetLayout("piano"); err == nil || err.Error() != "layout must be one of numpad, 3x3, 4x4, full" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := km.SetLayout("numpad"); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewKeymap(km.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := reloaded.Layout().Name; name != "numpad" {
+		t.Fatalf("expected the numpad layout to be saved, got %q", name)
+	}
+
+	if err := reloaded.SetLayout(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(km.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "layout") {
+		t.Errorf("expected the layout option to be removed, got:\n%s", saved)
+	}
+}
+
+func TestValidateLayout(t *testing.T) {
+	if problems := ValidateKey(KeySection{Name: LayoutSection, Options: []Option{{"row", "a b"}, {"row", "c:2"}}}); len(problems) != 0 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	problems := ValidateKey(KeySection{Name: LayoutSection, Options: []Option{{"row", "a b"}, {"command", "echo"}}})
+	if len(problems) != 1 || problems[0].Index != 1 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	problems = ValidateKey(KeySection{Name: LayoutSection, Options: []Option{{"row", "a a"}}})
+	if len(problems) != 1 || problems[0].Message != "Layout row 1: a is already in the layout" {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+}
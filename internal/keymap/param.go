@@ -0,0 +1,204 @@
+package keymap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParamTypes are the kinds of value a param declaration can ask for.
+var ParamTypes = []string{"text", "int", "number", "choice"}
+
+var ErrInvalidParam = errors.New("invalid param")
+
+// ParamSpec is a value a key asks for before it runs, declared as a name
+// followed by attributes:
+//
+//	param = volume type=int min=0 max=100 default=50
+//	param = mode choices=low|medium|high default=medium
+//	param = message default="hello there"
+//
+// The value is available to the command as {{.Params.name}}.
+type ParamSpec struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Choices []string `json:"choices,omitempty"`
+	Default string   `json:"default,omitempty"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+}
+
+// ParseParam reads a param declaration.
+func ParseParam(declaration string) (ParamSpec, error) {
+	fields, err := splitFields(declaration)
+	if err != nil {
+		return ParamSpec{}, err
+	}
+
+	if len(fields) == 0 {
+		return ParamSpec{}, errors.New("needs a name")
+	}
+
+	spec := ParamSpec{Name: fields[0], Type: "text"}
+	if !varNamePattern.MatchString(spec.Name) {
+		return ParamSpec{}, fmt.Errorf("%q is not a param name: use letters, digits and underscores", spec.Name)
+	}
+
+	for _, field := range fields[1:] {
+		attribute, value, found := strings.Cut(field, "=")
+		if !found {
+			return ParamSpec{}, fmt.Errorf("%q should be an attribute such as type=int", field)
+		}
+
+		switch attribute {
+		case "type":
+			if !slices.Contains(ParamTypes, value) {
+				return ParamSpec{}, fmt.Errorf("type must be one of %s", strings.Join(ParamTypes, ", "))
+			}
+			spec.Type = value
+		case "choices":
+			spec.Choices = strings.Split(value, "|")
+			spec.Type = "choice"
+		case "default":
+			spec.Default = value
+		case "min", "max":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return ParamSpec{}, fmt.Errorf("%s must be a number", attribute)
+			}
+			if attribute == "min" {
+				spec.Min = &n
+			} else {
+				spec.Max = &n
+			}
+		default:
+			return ParamSpec{}, fmt.Errorf("%q is not a param attribute", attribute)
+		}
+	}
+
+	if spec.Type == "choice" && len(spec.Choices) == 0 {
+		return ParamSpec{}, errors.New("a choice needs choices such as choices=low|high")
+	}
+
+	if spec.Default != "" {
+		if _, err := spec.Check(spec.Default); err != nil {
+			return ParamSpec{}, fmt.Errorf("default: %w", err)
+		}
+	}
+
+	return spec, nil
+}
+
+// splitFields splits on spaces, except within double quotes.
+func splitFields(s string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case r == ' ' && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if quoted {
+		return nil, errors.New("has an unclosed quote")
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
+
+// Check returns the value to use for a param, which is the default when
+// none is given.
+func (p ParamSpec) Check(value string) (string, error) {
+	if value == "" {
+		value = p.Default
+	}
+
+	if value == "" {
+		return "", fmt.Errorf("%s is required: %w", p.Name, ErrInvalidParam)
+	}
+
+	switch p.Type {
+	case "choice":
+		if !slices.Contains(p.Choices, value) {
+			return "", fmt.Errorf("%s must be one of %s: %w", p.Name, strings.Join(p.Choices, ", "), ErrInvalidParam)
+		}
+	case "int", "number":
+		n, err := strconv.ParseFloat(value, 64)
+		invalid := err != nil || math.IsNaN(n) || math.IsInf(n, 0)
+
+		switch {
+		case p.Type == "int" && (invalid || n != math.Trunc(n)):
+			return "", fmt.Errorf("%s must be a whole number: %w", p.Name, ErrInvalidParam)
+		case invalid:
+			return "", fmt.Errorf("%s must be a number: %w", p.Name, ErrInvalidParam)
+		case (p.Min != nil && n < *p.Min) || (p.Max != nil && n > *p.Max):
+			return "", fmt.Errorf("%s must be %s: %w", p.Name, p.bounds(), ErrInvalidParam)
+		}
+	}
+
+	return value, nil
+}
+
+func (p ParamSpec) bounds() string {
+	format := func(n float64) string {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+
+	switch {
+	case p.Min != nil && p.Max != nil:
+		return fmt.Sprintf("from %s to %s", format(*p.Min), format(*p.Max))
+	case p.Min != nil:
+		return "at least " + format(*p.Min)
+	default:
+		return "at most " + format(*p.Max)
+	}
+}
+
+// ResolveParams checks the params given when triggering the key against
+// what it declares, filling in defaults. Params it doesn't declare are
+// passed on as given.
+func (k *Key) ResolveParams(given map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(given))
+	for name, value := range given {
+		resolved[name] = value
+	}
+
+	for _, spec := range k.Params {
+		value, err := spec.Check(given[spec.Name])
+		if err != nil {
+			return nil, err
+		}
+		resolved[spec.Name] = value
+	}
+
+	return resolved, nil
+}
+
+// ParamsJSON describes the declared params for the browser, which asks for
+// them before triggering the key.
+func (k *Key) ParamsJSON() string {
+	// Encoding the specs can't fail.
+	encoded, _ := json.Marshal(k.Params)
+	return string(encoded)
+}
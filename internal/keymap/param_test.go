@@ -0,0 +1,115 @@
+package keymap
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestParseParam(t *testing.T) {
+	spec, err := ParseParam(`message default="hello there"`)
+	if err != nil || spec.Name != "message" || spec.Type != "text" || spec.Default != "hello there" {
+		t.Fatalf("unexpected spec %+v: %v", spec, err)
+	}
+
+	spec, err = ParseParam("mode choices=low|high default=low")
+	if err != nil || spec.Type != "choice" || len(spec.Choices) != 2 {
+		t.Fatalf("unexpected spec %+v: %v", spec, err)
+	}
+
+	for declaration, want := range map[string]string{
+		"":                               "needs a name",
+		"my-level":                       `"my-level" is not a param name: use letters, digits and underscores`,
+		"level int":                      `"int" should be an attribute such as type=int`,
+		"level type=date":                "type must be one of text, int, number, choice",
+		"level size=3":                   `"size" is not a param attribute`,
+		"level min=low":                  "min must be a number",
+		"level type=choice":              "a choice needs choices such as choices=low|high",
+		"level type=int max=5 default=9": "default: level must be at most 5: invalid param",
+		`level default="unclosed`:        "has an unclosed quote",
+	} {
+		if _, err := ParseParam(declaration); err == nil || err.Error() != want {
+			t.Errorf("expected %q for %q, got %v", want, declaration, err)
+		}
+	}
+}
+
+func TestParamCheck(t *testing.T) {
+	spec, err := ParseParam("level type=int min=0 max=10 default=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for value, want := range map[string]string{"": "5", "0": "0", "10": "10"} {
+		if got, err := spec.Check(value); err != nil || got != want {
+			t.Errorf("expected %q for %q, got %q: %v", want, value, got, err)
+		}
+	}
+
+	for _, value := range []string{"11", "-1", "2.5", "NaN", "1; reboot"} {
+		if _, err := spec.Check(value); !errors.Is(err, ErrInvalidParam) {
+			t.Errorf("expected ErrInvalidParam for %q, got %v", value, err)
+		}
+	}
+
+	number := ParamSpec{Name: "ratio", Type: "number"}
+	if _, err := number.Check("0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := number.Check("Inf"); err == nil || !strings.HasPrefix(err.Error(), "ratio must be a number") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	km := keymapFromContent(t, "[test]\nparam = level type=int default=5\nparam = who\ncommand = echo {{.Params.who}} {{.Params.level}}\n")
+
+	key := km.FindKeyByName("test")
+	if key == nil || len(key.Params) != 2 {
+		t.Fatal("expected a key with two params")
+	}
+
+	params, err := key.ResolveParams(map[string]string{"who": "me", "extra": "x"})
+	if err != nil || params["level"] != "5" || params["who"] != "me" || params["extra"] != "x" {
+		t.Fatalf("unexpected params %v: %v", params, err)
+	}
+
+	if _, err := key.ResolveParams(nil); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("expected ErrInvalidParam, got %v", err)
+	}
+
+	if json := key.ParamsJSON(); json != `[{"name":"level","type":"int","default":"5"},{"name":"who","type":"text"}]` {
+		t.Fatalf("unexpected JSON %s", json)
+	}
+}
+
+func TestValidateParam(t *testing.T) {
+	problems := ValidateKey(KeySection{Name: "a", Options: []Option{
+		{"param", "level type=int"},
+		{"param", "level"},
+		{"param", "x type=date"},
+		{"command", "echo"},
+	}})
+
+	if len(problems) != 2 || problems[0].Index+problems[1].Index != 3 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+}
+
+func TestBadParamIsLogged(t *testing.T) {
+	t.Cleanup(resetLogger)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	km := keymapFromContent(t, "[volume]\nphysical_key = v\nparam = level type=date\ncommand = echo {{.Params.level}}\n")
+
+	if km.FindKeyByName("volume") != nil {
+		t.Fatal("expected the key with a bad param to be left out")
+	}
+
+	if !strings.Contains(logged.String(), ":3: [volume] param type must be one of text, int, number, choice") {
+		t.Fatalf("expected the bad param to be logged, got %q", logged.String())
+	}
+}
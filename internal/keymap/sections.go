@@ -0,0 +1,436 @@
+package keymap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+var (
+	ErrKeyExists   = errors.New("a key or row with that name already exists")
+	ErrKeyNotFound = errors.New("no key or row with that name")
+)
+
+type Option struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// KeySection is a key or row as it is written in the config file, for
+// editing one at a time. Options given more than once appear once per
+// value, in order.
+type KeySection struct {
+	Name    string   `json:"name"`
+	Options []Option `json:"options"`
+	// File is the config file the section is in, as named by FileName. New
+	// sections go in the main file without it.
+	File string `json:"file,omitempty"`
+	// Position moves the section to that place among the others, counting
+	// from zero. Sections stay put, or new ones go last, without it.
+	Position *int `json:"position,omitempty"`
+}
+
+func (ks KeySection) IsRow() bool {
+	return strings.HasPrefix(ks.Name, "--")
+}
+
+// Sections lists the keys and rows in the order they are written, file by
+// file. It reads the files rather than the parsed content, which groups the
+// values of an option given more than once. Sections that were ignored
+// because an earlier file has them are left out.
+func (km *Keymap) Sections() []KeySection {
+	var sections []KeySection
+
+	for _, path := range km.Files() {
+		for _, b := range km.readBlocks(path)[1:] {
+			if b.name == ini.DefaultSection || km.origins[b.name] != path {
+				continue
+			}
+
+			ks := KeySection{Name: b.name, Options: []Option{}, File: km.FileName(path)}
+			for _, lines := range logicalLines(b.lines) {
+				if name, value, isOption := optionLine(strings.Join(lines, "\n")); isOption {
+					ks.Options = append(ks.Options, Option{Name: name, Value: value})
+				}
+			}
+
+			sections = append(sections, ks)
+		}
+	}
+
+	return sections
+}
+
+func (km *Keymap) Section(name string) (KeySection, error) {
+	for _, ks := range km.Sections() {
+		if ks.Name == name {
+			return ks, nil
+		}
+	}
+
+	return KeySection{}, ErrKeyNotFound
+}
+
+// The editing methods below work on the text of the config files rather
+// than on the parsed content, so that comments, blank lines and the
+// formatting of untouched options survive. Positions count the sections of
+// the file being changed.
+
+func (km *Keymap) AddKey(ks KeySection) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if problems := ValidateKey(ks); len(problems) > 0 {
+		return &ValidationError{Errors: problems}
+	}
+
+	path := km.Filename
+	if ks.File != "" {
+		var err error
+		if path, err = km.File(ks.File); err != nil {
+			return err
+		}
+	}
+
+	if _, found := km.origins[ks.Name]; found {
+		return ErrKeyExists
+	}
+
+	blocks := km.readBlocks(path)
+	if findBlock(blocks, ks.Name) > -1 {
+		return ErrKeyExists
+	}
+
+	b := block{name: ks.Name, lines: []string{"[" + ks.Name + "]"}}
+	b.setOptions(ks.Name, ks.Options)
+
+	position := len(blocks) - 1
+	if ks.Position != nil {
+		position = *ks.Position
+	}
+
+	return km.writeBlocks(path, insertBlock(blocks, b, position))
+}
+
+// AddKeys adds sections to the end of the main file in one save, such as
+// when importing another tool's config. Nothing is added if any of them
+// can't be.
+func (km *Keymap) AddKeys(sections []KeySection) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	blocks := km.readBlocks(km.Filename)
+
+	for _, ks := range sections {
+		if problems := ValidateKey(ks); len(problems) > 0 {
+			return fmt.Errorf("%s: %w", ks.Name, &ValidationError{Errors: problems})
+		}
+
+		if _, found := km.origins[ks.Name]; found || findBlock(blocks, ks.Name) > -1 {
+			return fmt.Errorf("%s: %w", ks.Name, ErrKeyExists)
+		}
+
+		b := block{name: ks.Name, lines: []string{"[" + ks.Name + "]"}}
+		b.setOptions(ks.Name, ks.Options)
+		blocks = insertBlock(blocks, b, len(blocks)-1)
+	}
+
+	return km.writeBlocks(km.Filename, blocks)
+}
+
+// UpdateKey replaces the options of a section, renaming it if the new
+// section has a different name.
+func (km *Keymap) UpdateKey(name string, ks KeySection) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if ks.Name == "" {
+		ks.Name = name
+	}
+
+	if problems := ValidateKey(ks); len(problems) > 0 {
+		return &ValidationError{Errors: problems}
+	}
+
+	path, found := km.origins[name]
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	blocks := km.readBlocks(path)
+	index := findBlock(blocks, name)
+	if index == -1 {
+		return ErrKeyNotFound
+	}
+
+	if _, taken := km.origins[ks.Name]; ks.Name != name && (taken || findBlock(blocks, ks.Name) > -1) {
+		return ErrKeyExists
+	}
+
+	b := blocks[index]
+	b.setOptions(ks.Name, ks.Options)
+	blocks[index] = b
+
+	if ks.Position != nil {
+		blocks = slices.Delete(blocks, index, index+1)
+		blocks = insertBlock(blocks, b, *ks.Position)
+	}
+
+	return km.writeBlocks(path, blocks)
+}
+
+func (km *Keymap) DeleteKey(name string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	path, found := km.origins[name]
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	blocks := km.readBlocks(path)
+	index := findBlock(blocks, name)
+	if index == -1 {
+		return ErrKeyNotFound
+	}
+
+	return km.writeBlocks(path, slices.Delete(blocks, index, index+1))
+}
+
+// readBlocks splits a config file into sections. Files in other formats
+// are edited as their INI equivalent.
+func (km *Keymap) readBlocks(path string) []block {
+	raw := km.ReadFile(path)
+	if !isINI(path) {
+		// The file loaded, so it converts.
+		raw, _ = toINI(path, raw)
+	}
+
+	return parseBlocks(raw)
+}
+
+func (km *Keymap) writeBlocks(path string, blocks []block) error {
+	raw := joinBlocks(blocks)
+	if !isINI(path) {
+		var err error
+		if raw, err = fromINI(path, raw); err != nil {
+			return err
+		}
+	}
+
+	return km.WriteRawFile(path, raw)
+}
+
+// block is a section of the config file as written: its heading, the
+// comments directly above it, and everything up to the next section. The
+// first block holds whatever comes before the first heading.
+type block struct {
+	name  string
+	lines []string
+}
+
+func parseBlocks(raw []byte) []block {
+	text := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+
+	blocks := []block{{}}
+	if text == "" {
+		return blocks
+	}
+
+	// A line of a value over several lines is never a heading.
+	for _, lines := range logicalLines(strings.Split(text, "\n")) {
+		line := lines[0]
+		name, isHeading := heading(line)
+		if !isHeading {
+			current := &blocks[len(blocks)-1]
+			current.lines = append(current.lines, lines...)
+			continue
+		}
+
+		current := &blocks[len(blocks)-1]
+		split := len(current.lines)
+		for split > 0 && isComment(current.lines[split-1]) {
+			split--
+		}
+
+		next := block{name: name, lines: slices.Clone(current.lines[split:])}
+		next.lines = append(next.lines, line)
+		current.lines = current.lines[:split]
+		blocks = append(blocks, next)
+	}
+
+	return blocks
+}
+
+func joinBlocks(blocks []block) []byte {
+	var lines []string
+	for _, b := range blocks {
+		lines = append(lines, b.lines...)
+	}
+
+	// Removing the last section can leave the blank line that separated it.
+	return []byte(strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n")
+}
+
+// findBlock returns the index of a named section, or -1.
+func findBlock(blocks []block, name string) int {
+	for i, b := range blocks[1:] {
+		if b.name == name {
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+// insertBlock places a section at a position among the sections, keeping a
+// blank line between it and its neighbours.
+func insertBlock(blocks []block, b block, position int) []block {
+	index := max(1, min(position+1, len(blocks)))
+
+	if len(b.lines) > 0 && strings.TrimSpace(b.lines[len(b.lines)-1]) != "" && index < len(blocks) {
+		b.lines = append(b.lines, "")
+	}
+
+	previous := &blocks[index-1]
+	if len(previous.lines) > 0 && strings.TrimSpace(previous.lines[len(previous.lines)-1]) != "" {
+		previous.lines = append(previous.lines, "")
+	}
+
+	return slices.Insert(blocks, index, b)
+}
+
+// setOptions rewrites a section to have the given options. Lines for
+// options that keep their value are left as they are, changed values are
+// rewritten in place, and new options go after the last existing one.
+func (b *block) setOptions(name string, options []Option) {
+	queued := make(map[string][]string)
+	for _, opt := range options {
+		queued[opt.Name] = append(queued[opt.Name], opt.Value)
+	}
+
+	var lines []string
+	insertAt := 0
+
+	for _, option := range logicalLines(b.lines) {
+		if _, isHeading := heading(option[0]); isHeading {
+			if name != b.name {
+				option = []string{"[" + name + "]"}
+			}
+			lines = append(lines, option...)
+			insertAt = len(lines)
+			continue
+		}
+
+		key, value, isOption := optionLine(strings.Join(option, "\n"))
+		if !isOption {
+			lines = append(lines, option...)
+			continue
+		}
+
+		values := queued[key]
+		if len(values) == 0 {
+			continue
+		}
+
+		if values[0] != value {
+			option = []string{formatOption(key, values[0])}
+		}
+
+		lines = append(lines, option...)
+		queued[key] = values[1:]
+		insertAt = len(lines)
+	}
+
+	var added []string
+	for _, opt := range options {
+		if values := queued[opt.Name]; len(values) > 0 {
+			added = append(added, formatOption(opt.Name, values[0]))
+			queued[opt.Name] = values[1:]
+		}
+	}
+
+	b.name = name
+	b.lines = slices.Insert(lines, insertAt, added...)
+}
+
+// logicalLines groups the lines of a block as they are loaded: one line
+// each, except that a value quoted with """ takes every line up to the one
+// that closes it.
+func logicalLines(lines []string) [][]string {
+	var logical [][]string
+	quoted := false
+
+	for _, line := range lines {
+		if quoted {
+			logical[len(logical)-1] = append(logical[len(logical)-1], line)
+			quoted = !strings.Contains(line, `"""`)
+			continue
+		}
+
+		logical = append(logical, []string{line})
+
+		if _, _, isOption := optionLine(line); isOption {
+			_, rest, found := strings.Cut(line, `"""`)
+			quoted = found && !strings.Contains(rest, `"""`)
+		}
+	}
+
+	return logical
+}
+
+func heading(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "[") || !strings.HasSuffix(trimmed, "]") {
+		return "", false
+	}
+
+	return strings.TrimSpace(trimmed[1 : len(trimmed)-1]), true
+}
+
+func isComment(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";")
+}
+
+// optionLine returns the name and value of an option line. The value is
+// read by the ini library so that quoting and inline comments are handled
+// the same way as when the file is loaded.
+func optionLine(line string) (string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || isComment(trimmed) {
+		return "", "", false
+	}
+
+	delimiter := strings.IndexAny(trimmed, "=:")
+	if delimiter < 1 {
+		return "", "", false
+	}
+
+	name := strings.TrimSpace(trimmed[:delimiter])
+
+	parsed, err := ini.Load([]byte(trimmed))
+	if err != nil {
+		return name, "", true
+	}
+
+	return name, parsed.Section("").Key(name).String(), true
+}
+
+// Values that would otherwise lose characters to comment stripping or
+// trimming are quoted, and those over several lines triple quoted.
+func formatOption(name, value string) string {
+	if strings.ContainsAny(value, "#;\n") || strings.TrimSpace(value) != value || strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "`") {
+		if !strings.ContainsAny(value, "`\n") {
+			value = "`" + value + "`"
+		} else {
+			value = `"""` + value + `"""`
+		}
+	}
+
+	return name + " = " + value
+}
@@ -0,0 +1,307 @@
+package keymap
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const sectionsFixture = `# Global settings
+sound = off
+
+# Says hello
+[hello]
+physical_key = h
+command = echo hello ; greeting
+
+[--Row 2]
+
+; Toggles
+[toggle]
+physical_key = t
+command = echo on
+state = on
+command = echo off
+state = off
+`
+
+func keymapFromContent(t *testing.T, content string) *Keymap {
+	t.Helper()
+	t.Cleanup(clearCache)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Kept beside the package rather than in the system temp dir so that
+	// saving can rename into place.
+	tempFile, err := os.CreateTemp(cwd, "keys-test-temp*.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempFile.Close()
+
+	t.Cleanup(func() {
+		if err := os.Remove(tempFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(HistoryDir(tempFile.Name())); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := os.WriteFile(tempFile.Name(), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := NewKeymap(tempFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return km
+}
+
+func TestSections(t *testing.T) {
+	km := keymapFromContent(t, sectionsFixture)
+
+	sections := km.Sections()
+	if len(sections) != 3 {
+		t.Fatalf("expected 3 sections, got %d", len(sections))
+	}
+
+	toggle := sections[2]
+	if toggle.Name != "toggle" || len(toggle.Options) != 5 {
+		t.Fatalf("unexpected section %+v", toggle)
+	}
+
+	if toggle.Options[3] != (Option{Name: "command", Value: "echo off"}) {
+		t.Fatalf("shadowed options out of order: %+v", toggle.Options)
+	}
+
+	if _, err := km.Section("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestParseBlocks(t *testing.T) {
+	blocks := parseBlocks([]byte(sectionsFixture))
+
+	if len(blocks) != 4 {
+		t.Fatalf("expected 4 blocks, got %d", len(blocks))
+	}
+
+	if blocks[1].lines[0] != "# Says hello" {
+		t.Fatalf("comment did not move with its section: %q", blocks[1].lines)
+	}
+
+	if string(joinBlocks(blocks)) != sectionsFixture {
+		t.Fatalf("round trip changed the file:\n%s", joinBlocks(blocks))
+	}
+}
+
+func TestAddKey(t *testing.T) {
+	km := keymapFromContent(t, sectionsFixture)
+
+	position := 1
+	err := km.AddKey(KeySection{
+		Name:     "bye",
+		Options:  []Option{{Name: "physical_key", Value: "b"}, {Name: "command", Value: "echo #bye"}},
+		Position: &position,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key := km.FindKeyByName("bye"); key == nil || key.Commands[0] != "echo #bye" {
+		t.Fatalf("added key not found or mangled: %+v", key)
+	}
+
+	if names := sectionNames(km); names != "hello bye --Row 2 toggle" {
+		t.Fatalf("unexpected order %q", names)
+	}
+
+	if !strings.Contains(string(km.Raw()), "# Says hello") {
+		t.Fatal("comments were lost")
+	}
+
+	if err := km.AddKey(KeySection{Name: "bye", Options: []Option{{Name: "command", Value: "true"}}}); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+}
+
+func TestUpdateKey(t *testing.T) {
+	km := keymapFromContent(t, sectionsFixture)
+
+	err := km.UpdateKey("hello", KeySection{
+		Name: "greet",
+		Options: []Option{
+			{Name: "physical_key", Value: "h"},
+			{Name: "command", Value: "echo hello"},
+			{Name: "timeout", Value: "5"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := string(km.Raw())
+	for _, want := range []string{"# Says hello\n[greet]\n", "command = echo hello ; greeting\ntimeout = 5\n", "; Toggles"} {
+		if !strings.Contains(raw, want) {
+			t.Fatalf("expected %q in:\n%s", want, raw)
+		}
+	}
+
+	position := 0
+	err = km.UpdateKey("toggle", KeySection{
+		Options:  []Option{{Name: "physical_key", Value: "t"}, {Name: "command", Value: "echo on"}},
+		Position: &position,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := sectionNames(km); names != "toggle greet --Row 2" {
+		t.Fatalf("unexpected order %q", names)
+	}
+
+	if key := km.FindKeyByName("toggle"); key == nil || len(key.Commands) != 1 {
+		t.Fatalf("expected one command left: %+v", key)
+	}
+
+	err = km.UpdateKey("toggle", KeySection{Name: "greet", Options: []Option{{Name: "command", Value: "true"}}})
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	var validationErr *ValidationError
+	err = km.UpdateKey("toggle", KeySection{Options: []Option{{Name: "comand", Value: "true"}}})
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+}
+
+func TestMultilineValues(t *testing.T) {
+	command := "curl http://x\n[done]\necho done: ok=1"
+	km := keymapFromContent(t, "[fetch]\nphysical_key = f\ncommand = \"\"\""+command+"\"\"\"\ntimeout = 5\n")
+
+	ks, err := km.Section("fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ks.Options) != 3 || ks.Options[1] != (Option{"command", command}) {
+		t.Fatalf("unexpected options %v", ks.Options)
+	}
+
+	ks.Options[2].Value = "10"
+	if err := km.UpdateKey("fetch", ks); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "command = \"\"\"" + command + "\"\"\"\ntimeout = 10\n"
+	if raw := string(km.Raw()); !strings.Contains(raw, want) {
+		t.Fatalf("expected %q in:\n%s", want, raw)
+	}
+
+	ks.Options[1].Value = "echo once\necho twice"
+	if err := km.UpdateKey("fetch", ks); err != nil {
+		t.Fatal(err)
+	}
+
+	if updated, _ := km.Section("fetch"); len(updated.Options) != 3 || updated.Options[1] != ks.Options[1] {
+		t.Fatalf("unexpected options %v in:\n%s", updated.Options, km.Raw())
+	}
+}
+
+func TestDeleteKey(t *testing.T) {
+	km := keymapFromContent(t, sectionsFixture)
+
+	if err := km.DeleteKey("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := string(km.Raw())
+	if strings.Contains(raw, "hello") {
+		t.Fatalf("section or its comment remains:\n%s", raw)
+	}
+
+	if !strings.HasPrefix(raw, "# Global settings\nsound = off\n") {
+		t.Fatalf("global settings were disturbed:\n%s", raw)
+	}
+
+	if err := km.DeleteKey("hello"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		section KeySection
+		fields  []string
+	}{
+		{"valid", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "true"}, {Name: "timeout", Value: "5"}}}, nil},
+		{"row", KeySection{Name: "--Row", Options: []Option{}}, nil},
+		{"row with options", KeySection{Name: "--Row", Options: []Option{{Name: "command", Value: "true"}}}, []string{"name"}},
+		{"no name", KeySection{Options: []Option{{Name: "command", Value: "true"}}}, []string{"name"}},
+		{"no command", KeySection{Name: "a", Options: []Option{{Name: "physical_key", Value: "a"}}}, []string{"command"}},
+		{"unknown option", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "true"}, {Name: "comand", Value: "true"}}}, []string{"comand"}},
+		{"bad bool", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "true"}, {Name: "output", Value: "maybe"}}}, []string{"output"}},
+		{"duplicate", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "true"}, {Name: "timeout", Value: "1"}, {Name: "timeout", Value: "2"}}}, []string{"timeout"}},
+		{"line break", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "true\n[b]"}}}, nil},
+		{"triple quote", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "echo \"\"\"\ntrue"}}}, []string{"command"}},
+		{"missing states", KeySection{Name: "a", Options: []Option{{Name: "command", Value: "true"}, {Name: "command", Value: "false"}}}, []string{"state"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fields []string
+			for _, problem := range ValidateKey(test.section) {
+				fields = append(fields, problem.Field)
+			}
+
+			if strings.Join(fields, " ") != strings.Join(test.fields, " ") {
+				t.Fatalf("expected problems with %v, got %v", test.fields, fields)
+			}
+		})
+	}
+}
+
+func sectionNames(km *Keymap) string {
+	var names []string
+	for _, ks := range km.Sections() {
+		names = append(names, ks.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func TestAddKeys(t *testing.T) {
+	km := keymapFromContent(t, "[a]\ncommand = echo a\n")
+
+	err := km.AddKeys([]KeySection{
+		{Name: "b", Options: []Option{{"command", "echo b"}}},
+		{Name: "a", Options: []Option{{"command", "echo again"}}},
+	})
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	if km.FindKeyByName("b") != nil {
+		t.Fatal("expected nothing to be added when one section can't be")
+	}
+
+	if err := km.AddKeys([]KeySection{
+		{Name: "b", Options: []Option{{"command", "echo b"}}},
+		{Name: "c", Options: []Option{{"command", "echo c"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if names := sectionNames(km); names != "a b c" {
+		t.Fatalf("unexpected sections %q", names)
+	}
+}
@@ -0,0 +1,116 @@
+package keymap
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"gopkg.in/ini.v1"
+)
+
+// VarsSection holds values that commands can refer to as {{.Vars.name}}.
+// It is not a key.
+const VarsSection = "vars"
+
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Param is a value given when a key is triggered, such as a query parameter
+// of POST /trigger/{key}. It comes from whoever triggered the key, so it is
+// shell quoted when written into a command unless .Raw is used.
+type Param string
+
+func (p Param) String() string {
+	return ShellQuote(string(p))
+}
+
+func (p Param) Raw() string {
+	return string(p)
+}
+
+// commandData is what a command template can refer to.
+type commandData struct {
+	Name   string
+	State  string
+	Source string
+	Vars   map[string]string
+	Params map[string]Param
+}
+
+var commandFuncs = template.FuncMap{
+	"quote": quote,
+	"env":   os.Getenv,
+}
+
+// ShellQuote makes a value a single word to sh, with nothing in it
+// expanded.
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// quote is ShellQuote for templates. Params are quoted once, not twice.
+func quote(value any) string {
+	if p, isParam := value.(Param); isParam {
+		return p.String()
+	}
+
+	return ShellQuote(fmt.Sprint(value))
+}
+
+func isTemplate(command string) bool {
+	return strings.Contains(command, "{{")
+}
+
+func parseCommand(name, command string) (*template.Template, error) {
+	return template.New(name).Funcs(commandFuncs).Option("missingkey=error").Parse(command)
+}
+
+// expandCommand fills in the template of the command about to run. Commands
+// without a template are used as written.
+func (k *Key) expandCommand(source string, params map[string]string) (string, error) {
+	command := k.CurrentCommand()
+	if !isTemplate(command) {
+		return command, nil
+	}
+
+	tmpl, err := parseCommand(k.Name, command)
+	if err != nil {
+		return "", err
+	}
+
+	data := commandData{
+		Name:   k.Name,
+		State:  k.State(),
+		Source: source,
+		Vars:   k.Vars,
+		Params: make(map[string]Param, len(params)),
+	}
+	for name, value := range params {
+		data.Params[name] = Param(value)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("could not fill in the command of %s: %w", k.Name, err)
+	}
+
+	return out.String(), nil
+}
+
+// readVars returns the vars section with environment variables such as
+// $HOME expanded.
+func readVars(content *ini.File) map[string]string {
+	vars := make(map[string]string)
+
+	section, err := content.GetSection(VarsSection)
+	if err != nil {
+		return vars
+	}
+
+	for _, key := range section.Keys() {
+		vars[key.Name()] = os.ExpandEnv(key.String())
+	}
+
+	return vars
+}
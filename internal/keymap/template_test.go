@@ -0,0 +1,83 @@
+package keymap
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, value := range []string{"plain", "two words", "it's", "$(rm -rf ~); `id` \"x\" \\"} {
+		out, err := exec.Command("sh", "-c", "printf %s "+ShellQuote(value)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(out) != value {
+			t.Errorf("expected %q back from the shell, got %q", value, out)
+		}
+	}
+}
+
+func TestExpandCommand(t *testing.T) {
+	key := &Key{
+		Name:     "deploy",
+		Commands: []string{"deploy {{.Vars.host}} {{.Params.branch}} {{quote .Params.branch}} {{.Params.branch.Raw}} {{.Name}} {{.State}} {{.Source}} {{index .Params \"missing\"}}", "stop"},
+		States:   []string{"idle", "running"},
+		Vars:     map[string]string{"host": "example.com"},
+	}
+
+	command, err := key.expandCommand("api", map[string]string{"branch": "main; rm -rf ~"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "deploy example.com 'main; rm -rf ~' 'main; rm -rf ~' main; rm -rf ~ deploy idle api ''"
+	if command != want {
+		t.Fatalf("expected %q, got %q", want, command)
+	}
+
+	key.Commands[0] = "deploy {{.Params.branch}}"
+	if _, err := key.expandCommand("keyboard", nil); err == nil {
+		t.Fatal("expected an error for a missing param")
+	}
+
+	if command, err := (&Key{Commands: []string{"echo $HOME {}"}}).expandCommand("", nil); err != nil || command != "echo $HOME {}" {
+		t.Fatalf("expected a command without a template to be left alone, got %q: %v", command, err)
+	}
+}
+
+func TestVars(t *testing.T) {
+	km := keymapFromContent(t, "[vars]\nhome = ${HOME}/keys\n\n[test]\ncommand = echo {{.Vars.home}}\n")
+
+	if km.Vars["home"] != os.Getenv("HOME")+"/keys" {
+		t.Fatalf("expected environment variables to be expanded, got %q", km.Vars["home"])
+	}
+
+	if km.FindKeyByName(VarsSection) != nil {
+		t.Fatal("the vars section is not a key")
+	}
+
+	key := km.FindKey("test")
+	stdout, err := key.RunCommandContext(t.Context(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(stdout)) != km.Vars["home"] {
+		t.Fatalf("unexpected output %q", stdout)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	problems := ValidateKey(KeySection{Name: "a", Options: []Option{{"command", "echo {{.Params.x"}}})
+	if len(problems) != 1 || !strings.HasPrefix(problems[0].Message, "command is not a valid template") {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+
+	problems = ValidateKey(KeySection{Name: VarsSection, Options: []Option{{"host", "x"}, {"api-token", "y"}}})
+	if len(problems) != 1 || problems[0].Index != 1 {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+}
@@ -0,0 +1,164 @@
+package keymap
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// tomlFormat has the global options at the top and a [[keys]] table for
+// each key or row, with its name and options. Options given more than once
+// are arrays.
+//
+//	sound = "off"
+//
+//	[[keys]]
+//	name = "lock"
+//	physical_key = "l"
+//	command = ["lock", "unlock"]
+type tomlFormat struct{}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (tomlFormat) Decode(raw []byte) (Document, error) {
+	var values map[string]any
+	meta, err := toml.Decode(string(raw), &values)
+	if err != nil {
+		return Document{}, err
+	}
+
+	tables, isTables := values[sectionsField].([]map[string]any)
+	if _, found := values[sectionsField]; found && !isTables {
+		return Document{}, fmt.Errorf("%s must be an array of tables, written [[%s]]", sectionsField, sectionsField)
+	}
+
+	// The values are in maps, so the order they were written in comes from
+	// the keys of the metadata. Each [[keys]] starts the next table.
+	var doc Document
+	for _, key := range meta.Keys() {
+		switch {
+		case len(key) == 1 && key[0] == sectionsField:
+			doc.Sections = append(doc.Sections, KeySection{Options: []Option{}})
+		case len(key) == 1:
+			options, err := tomlOptions(key[0], values[key[0]])
+			if err != nil {
+				return Document{}, err
+			}
+			doc.Options = append(doc.Options, options...)
+		case len(key) == 2 && key[0] == sectionsField:
+			i := len(doc.Sections) - 1
+			ks := &doc.Sections[i]
+			value := tables[i][key[1]]
+
+			if key[1] == "name" {
+				name, ok := value.(string)
+				if !ok {
+					return Document{}, fmt.Errorf("%s %d: name must be a string", sectionsField, i+1)
+				}
+				ks.Name = name
+				continue
+			}
+
+			options, err := tomlOptions(key[1], value)
+			if err != nil {
+				return Document{}, fmt.Errorf("%s %d: %w", sectionsField, i+1, err)
+			}
+			ks.Options = append(ks.Options, options...)
+		default:
+			return Document{}, fmt.Errorf("%s must be a string, number, boolean or an array of them", key)
+		}
+	}
+
+	for i, ks := range doc.Sections {
+		if ks.Name == "" {
+			return Document{}, fmt.Errorf("%s %d: name is missing", sectionsField, i+1)
+		}
+	}
+
+	return doc, nil
+}
+
+// tomlOptions reads an option's value, or values if it is an array. Numbers
+// and booleans become text, since that is how INI has them.
+func tomlOptions(name string, value any) ([]Option, error) {
+	values, isArray := value.([]any)
+	if !isArray {
+		values = []any{value}
+	}
+
+	var options []Option
+	for _, v := range values {
+		var text string
+		switch v := v.(type) {
+		case string:
+			text = v
+		case int64:
+			text = strconv.FormatInt(v, 10)
+		case float64:
+			text = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			text = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s must be a string, number, boolean or an array of them", name)
+		}
+		options = append(options, Option{Name: name, Value: text})
+	}
+
+	return options, nil
+}
+
+func (tomlFormat) Encode(doc Document) ([]byte, error) {
+	var out strings.Builder
+
+	out.WriteString(tomlFields(doc.Options))
+
+	for _, ks := range doc.Sections {
+		if out.Len() > 0 {
+			out.WriteString("\n")
+		}
+
+		out.WriteString("[[" + sectionsField + "]]\n")
+		out.WriteString("name = " + tomlText(ks.Name) + "\n")
+		out.WriteString(tomlFields(ks.Options))
+	}
+
+	return []byte(out.String()), nil
+}
+
+// tomlFields writes options one per line, in the order each name first
+// appears. Values are always strings, since INI doesn't say which options
+// are numbers.
+func tomlFields(options []Option) string {
+	names, values := groupOptions(options)
+
+	var out strings.Builder
+	for _, name := range names {
+		var encoded []string
+		for _, value := range values[name] {
+			encoded = append(encoded, tomlText(value))
+		}
+
+		value := encoded[0]
+		if len(encoded) > 1 {
+			value = "[" + strings.Join(encoded, ", ") + "]"
+		}
+
+		key := name
+		if !tomlBareKey.MatchString(key) {
+			key = tomlText(key)
+		}
+
+		out.WriteString(key + " = " + value + "\n")
+	}
+
+	return out.String()
+}
+
+// tomlText writes a basic string. The escapes of a JSON string are ones
+// TOML has too.
+func tomlText(value string) string {
+	return jsonText(value)
+}
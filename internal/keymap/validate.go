@@ -0,0 +1,244 @@
+package keymap
+
+import (
+	"fmt"
+	"keys/internal/output"
+	"keys/internal/schedule"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type optionKind int
+
+const (
+	textOption optionKind = iota
+	boolOption
+	intOption
+	secondsOption
+	volumeOption
+	scheduleOption
+	choiceOption
+	paramOption
+)
+
+type optionSpec struct {
+	kind    optionKind
+	choices []string
+	min     int
+	max     int
+}
+
+// keyOptions are the options a key section understands. Anything else is
+// most likely a typo.
+var keyOptions = map[string]optionSpec{
+	"physical_key":         {kind: textOption},
+	"command":              {kind: textOption},
+	"state":                {kind: textOption},
+	"output":               {kind: boolOption},
+	"output_format":        {kind: choiceOption, choices: output.Formats},
+	"timeout":              {kind: secondsOption},
+	"confirmation":         {kind: boolOption},
+	"probe":                {kind: textOption},
+	"probe_interval":       {kind: secondsOption},
+	"schedule":             {kind: scheduleOption},
+	"schedule_when_locked": {kind: boolOption},
+	"require_confirm":      {kind: boolOption},
+	"cooldown":             {kind: secondsOption},
+	"max_concurrent":       {kind: intOption, min: 0, max: 1000},
+	"throttle":             {kind: choiceOption, choices: []string{"reject", "queue"}},
+	"user":                 {kind: textOption},
+	"nice":                 {kind: intOption, min: -20, max: 19},
+	"ionice":               {kind: textOption},
+	"limit":                {kind: textOption},
+	"sandbox":              {kind: textOption},
+	"sandbox_sources":      {kind: textOption},
+	"kill_grace":           {kind: secondsOption},
+	"detach":               {kind: boolOption},
+	"notify":               {kind: choiceOption, choices: NotifyModes},
+	"sound":                {kind: textOption},
+	"sound_volume":         {kind: volumeOption},
+	"speech":               {kind: choiceOption, choices: SpeechModes},
+	"param":                {kind: paramOption},
+}
+
+// Options that may be given more than once.
+var shadowedOptions = []string{"command", "state", "limit", "param"}
+
+var boolValues = []string{"1", "0", "t", "f", "true", "false", "yes", "no", "y", "n", "on", "off"}
+
+type FieldError struct {
+	Field string `json:"field"`
+	// Index is the position of the offending option in the section, or -1
+	// for problems with the section as a whole.
+	Index   int    `json:"index"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Message
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// ValidateKey checks a section before it is written to the config file.
+// Rows only need a name, the vars section names that can be used in
+// templates, and the layout section rows that parse.
+func ValidateKey(ks KeySection) []FieldError {
+	var problems []FieldError
+	fail := func(field string, index int, format string, args ...any) {
+		problems = append(problems, FieldError{Field: field, Index: index, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case strings.TrimSpace(ks.Name) == "":
+		fail("name", -1, "Name is required")
+	case strings.ContainsAny(ks.Name, "[]\r\n"):
+		fail("name", -1, "Name cannot contain brackets or line breaks")
+	case strings.EqualFold(ks.Name, "DEFAULT"):
+		fail("name", -1, "DEFAULT is reserved for global options")
+	case strings.TrimSpace(ks.Name) != ks.Name:
+		fail("name", -1, "Name cannot start or end with spaces")
+	}
+
+	if ks.IsRow() {
+		if len(ks.Options) > 0 {
+			fail("name", -1, "Rows cannot have options")
+		}
+		return problems
+	}
+
+	if ks.Name == VarsSection {
+		for i, opt := range ks.Options {
+			if !varNamePattern.MatchString(opt.Name) {
+				fail(opt.Name, i, "%q is not a variable name: use letters, digits and underscores", opt.Name)
+			}
+		}
+		return problems
+	}
+
+	if ks.Name == LayoutSection {
+		var rows []string
+		for i, opt := range ks.Options {
+			if opt.Name != "row" {
+				fail(opt.Name, i, "%q is not a layout option: give each row of keys as row", opt.Name)
+				continue
+			}
+			rows = append(rows, opt.Value)
+		}
+		if _, err := ParseLayout(rows); err != nil {
+			fail("row", -1, "Layout %s", err)
+		}
+		return problems
+	}
+
+	counts := make(map[string]int)
+	for i, opt := range ks.Options {
+		counts[opt.Name]++
+
+		spec, known := keyOptions[opt.Name]
+		if !known {
+			fail(opt.Name, i, "%q is not a key option", opt.Name)
+			continue
+		}
+
+		if counts[opt.Name] == 2 && !slices.Contains(shadowedOptions, opt.Name) {
+			fail(opt.Name, i, "%s can only be given once", opt.Name)
+		}
+
+		// Values over several lines are written between """, which they
+		// can't contain themselves.
+		if strings.Contains(opt.Value, "\r") {
+			fail(opt.Name, i, "%s cannot contain carriage returns", opt.Name)
+			continue
+		}
+
+		if strings.Contains(opt.Value, `"""`) {
+			fail(opt.Name, i, `%s cannot contain """`, opt.Name)
+			continue
+		}
+
+		if message := spec.check(strings.TrimSpace(opt.Value)); message != "" {
+			fail(opt.Name, i, "%s %s", opt.Name, message)
+		}
+
+		if opt.Name == "command" && isTemplate(opt.Value) {
+			if _, err := parseCommand(ks.Name, opt.Value); err != nil {
+				fail(opt.Name, i, "command is not a valid template: %s", strings.TrimPrefix(err.Error(), "template: "))
+			}
+		}
+	}
+
+	params := make(map[string]bool)
+	for i, opt := range ks.Options {
+		if opt.Name != "param" {
+			continue
+		}
+
+		if spec, err := ParseParam(opt.Value); err == nil {
+			if params[spec.Name] {
+				fail(opt.Name, i, "param %s is declared more than once", spec.Name)
+			}
+			params[spec.Name] = true
+		}
+	}
+
+	if counts["command"] == 0 {
+		fail("command", -1, "At least one command is required")
+	}
+
+	if counts["command"] > 1 && counts["command"] != counts["state"] {
+		fail("state", -1, "Each command needs a state when there is more than one command")
+	}
+
+	return problems
+}
+
+func (spec optionSpec) check(value string) string {
+	switch spec.kind {
+	case boolOption:
+		if !slices.Contains(boolValues, strings.ToLower(value)) {
+			return "must be on or off"
+		}
+	case intOption:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < spec.min || n > spec.max {
+			return fmt.Sprintf("must be a whole number from %d to %d", spec.min, spec.max)
+		}
+	case volumeOption:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 100 {
+			return "must be a whole number from 0 to 100"
+		}
+	case secondsOption:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || n < 0 {
+			return "must be a number of seconds"
+		}
+	case scheduleOption:
+		if _, err := schedule.Parse(value); err != nil {
+			return err.Error()
+		}
+	case paramOption:
+		if _, err := ParseParam(value); err != nil {
+			return err.Error()
+		}
+	case choiceOption:
+		if !slices.Contains(spec.choices, value) {
+			return "must be one of " + strings.Join(spec.choices, ", ")
+		}
+	case textOption:
+		if value == "" {
+			return "cannot be empty"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,178 @@
+package keymap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlFormat is laid out as jsonFormat is: a mapping of global options with
+// the keys and rows as a list under keys.
+//
+//	sound: "off"
+//	keys:
+//	  - name: lock
+//	    physical_key: l
+//	    command: [lock, unlock]
+type yamlFormat struct{}
+
+func (yamlFormat) Decode(raw []byte) (Document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return Document{}, err
+	}
+
+	// An empty file has no document at all.
+	if len(root.Content) == 0 {
+		return Document{}, nil
+	}
+
+	top := resolveAlias(root.Content[0])
+	if top.Kind != yaml.MappingNode {
+		return Document{}, errors.New("config must be a YAML mapping")
+	}
+
+	var doc Document
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		name, value := top.Content[i].Value, resolveAlias(top.Content[i+1])
+
+		if name != sectionsField {
+			options, err := yamlOptions(name, value)
+			if err != nil {
+				return Document{}, err
+			}
+			doc.Options = append(doc.Options, options...)
+			continue
+		}
+
+		if value.Kind != yaml.SequenceNode {
+			return Document{}, fmt.Errorf("%s must be a list of mappings", sectionsField)
+		}
+
+		for j, node := range value.Content {
+			ks, err := yamlSection(resolveAlias(node))
+			if err != nil {
+				return Document{}, fmt.Errorf("%s %d: %w", sectionsField, j+1, err)
+			}
+			doc.Sections = append(doc.Sections, ks)
+		}
+	}
+
+	return doc, nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	return node
+}
+
+func yamlSection(node *yaml.Node) (KeySection, error) {
+	if node.Kind != yaml.MappingNode {
+		return KeySection{}, errors.New("must be a mapping")
+	}
+
+	ks := KeySection{Options: []Option{}}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := node.Content[i].Value, resolveAlias(node.Content[i+1])
+
+		if name == "name" {
+			if value.Kind != yaml.ScalarNode || value.Tag != "!!str" {
+				return KeySection{}, errors.New("name must be a string")
+			}
+			ks.Name = value.Value
+			continue
+		}
+
+		options, err := yamlOptions(name, value)
+		if err != nil {
+			return KeySection{}, err
+		}
+		ks.Options = append(ks.Options, options...)
+	}
+
+	if ks.Name == "" {
+		return KeySection{}, errors.New("name is missing")
+	}
+
+	return ks, nil
+}
+
+// yamlOptions reads an option's value, or values if it is a list. Numbers
+// and booleans are kept as written, since that is how INI has them.
+func yamlOptions(name string, node *yaml.Node) ([]Option, error) {
+	values := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		values = node.Content
+	}
+
+	var options []Option
+	for _, value := range values {
+		value = resolveAlias(value)
+
+		switch {
+		case value.Kind != yaml.ScalarNode:
+			return nil, fmt.Errorf("%s must be a string, number, boolean or a list of them", name)
+		case value.Tag == "!!null":
+			continue
+		default:
+			options = append(options, Option{Name: name, Value: value.Value})
+		}
+	}
+
+	return options, nil
+}
+
+func (yamlFormat) Encode(doc Document) ([]byte, error) {
+	top := yamlMapping(doc.Options)
+
+	sections := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, ks := range doc.Sections {
+		section := yamlMapping(ks.Options)
+		section.Content = append([]*yaml.Node{yamlText("name"), yamlText(ks.Name)}, section.Content...)
+		sections.Content = append(sections.Content, section)
+	}
+	top.Content = append(top.Content, yamlText(sectionsField), sections)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(top); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// yamlMapping holds options in the order each name first appears. Values
+// are always strings, since INI doesn't say which options are numbers.
+func yamlMapping(options []Option) *yaml.Node {
+	names, values := groupOptions(options)
+
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range names {
+		value := yamlText(values[name][0])
+		if len(values[name]) > 1 {
+			value = &yaml.Node{Kind: yaml.SequenceNode}
+			for _, v := range values[name] {
+				value.Content = append(value.Content, yamlText(v))
+			}
+		}
+
+		mapping.Content = append(mapping.Content, yamlText(name), value)
+	}
+
+	return mapping
+}
+
+func yamlText(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"html"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/godbus/dbus/v5"
+)
+
+var ErrNoBus = errors.New("no session bus address")
+
+const (
+	maxBodyLength = 200
+	busTimeout    = 2 * time.Second
+)
+
+type Notification struct {
+	// Notifications with the same tag replace each other rather than
+	// piling up, so repeated presses of a key show a single bubble.
+	Tag      string
+	Summary  string
+	Body     string
+	Critical bool
+}
+
+type Notifier struct {
+	Address string
+	AppName string
+	mu      sync.Mutex
+	ids     map[string]uint32
+}
+
+// NewNotifier sends notifications through the
+// org.freedesktop.Notifications service on the session bus at address,
+// which is normally the value of DBUS_SESSION_BUS_ADDRESS.
+func NewNotifier(address string) *Notifier {
+	return &Notifier{
+		Address: address,
+		AppName: "keys",
+		ids:     make(map[string]uint32),
+	}
+}
+
+func (n *Notifier) Send(notification Notification) error {
+	if n.Address == "" {
+		return ErrNoBus
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), busTimeout)
+	defer cancel()
+
+	bus, err := dbus.Connect(n.Address, dbus.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	urgency := byte(1)
+	if notification.Critical {
+		urgency = 2
+	}
+
+	var id uint32
+	err = bus.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications").CallWithContext(
+		ctx,
+		"org.freedesktop.Notifications.Notify",
+		0,
+		n.AppName,
+		n.ids[notification.Tag],
+		"",
+		notification.Summary,
+		html.EscapeString(truncate(notification.Body)),
+		[]string{},
+		map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency)},
+		int32(-1),
+	).Store(&id)
+	if err != nil {
+		return err
+	}
+
+	if notification.Tag != "" {
+		n.ids[notification.Tag] = id
+	}
+
+	return nil
+}
+
+func truncate(body string) string {
+	body = strings.TrimSpace(body)
+	if utf8.RuneCountInString(body) <= maxBodyLength {
+		return body
+	}
+
+	runes := []rune(body)
+	return string(runes[:maxBodyLength-1]) + "…"
+}
@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"bufio"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type received struct {
+	replacesID uint32
+	summary    string
+	body       string
+}
+
+type daemon struct {
+	ids   []uint32
+	calls chan received
+}
+
+func (d *daemon) Notify(app string, replacesID uint32, icon, summary, body string, actions []string, hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+	d.calls <- received{replacesID, summary, body}
+
+	id := d.ids[0]
+	d.ids = d.ids[1:]
+	return id, nil
+}
+
+// standIn starts a private session bus with a notification daemon on it,
+// which replies to each Notify call with the given ids in turn.
+func standIn(t *testing.T, ids ...uint32) (string, chan received) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	address := "unix:path=" + filepath.Join(t.TempDir(), "bus")
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address", "--address="+address)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	// The address is printed once the bus is listening.
+	address, err = bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	address = strings.TrimSpace(address)
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	calls := make(chan received, len(ids))
+	if err := conn.Export(&daemon{ids, calls}, "/org/freedesktop/Notifications", "org.freedesktop.Notifications"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.RequestName("org.freedesktop.Notifications", dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	return address, calls
+}
+
+func TestSend(t *testing.T) {
+	address, calls := standIn(t, 7, 8)
+	notifier := NewNotifier(address)
+
+	err := notifier.Send(Notification{Tag: "test", Summary: "test (on)", Body: "a < b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := <-calls
+	if first.summary != "test (on)" || first.body != "a &lt; b" || first.replacesID != 0 {
+		t.Errorf("Unexpected notification: %+v", first)
+	}
+
+	if err := notifier.Send(Notification{Tag: "test", Summary: "test (off)"}); err != nil {
+		t.Fatal(err)
+	}
+
+	second := <-calls
+	if second.replacesID != 7 {
+		t.Errorf("Second notification should have replaced the first, got %d", second.replacesID)
+	}
+}
+
+func TestSendWithoutBus(t *testing.T) {
+	if err := NewNotifier("").Send(Notification{Summary: "test"}); err != ErrNoBus {
+		t.Errorf("Expected ErrNoBus, got %v", err)
+	}
+
+	address := "unix:path=" + filepath.Join(t.TempDir(), "missing")
+	if err := NewNotifier(address).Send(Notification{Summary: "test"}); err == nil {
+		t.Error("Sending to a missing bus should fail")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("é", maxBodyLength+10)
+
+	truncated := truncate(long)
+	if got := len([]rune(truncated)); got != maxBodyLength {
+		t.Errorf("Expected %d characters, got %d", maxBodyLength, got)
+	}
+
+	if !strings.HasSuffix(truncated, "…") {
+		t.Error("Truncated body should end with an ellipsis")
+	}
+
+	if truncate(" short \n") != "short" {
+		t.Error("Short bodies should only be trimmed")
+	}
+}
@@ -0,0 +1,139 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var escapeSequence = regexp.MustCompile(`\x1b\[([0-9;?]*)([A-Za-z])`)
+
+type ansiStyle struct {
+	bold      bool
+	italic    bool
+	underline bool
+	fg        string
+	bg        string
+}
+
+func (s ansiStyle) classes() string {
+	var classes []string
+
+	if s.bold {
+		classes = append(classes, "ansi-bold")
+	}
+
+	if s.italic {
+		classes = append(classes, "ansi-italic")
+	}
+
+	if s.underline {
+		classes = append(classes, "ansi-underline")
+	}
+
+	if s.fg != "" {
+		classes = append(classes, "ansi-fg-"+s.fg)
+	}
+
+	if s.bg != "" {
+		classes = append(classes, "ansi-bg-"+s.bg)
+	}
+
+	return strings.Join(classes, " ")
+}
+
+// Only SGR sequences are converted, to spans with classes rather than inline
+// styles because of the content security policy. Other escape sequences such
+// as cursor movement are dropped.
+func ANSI(stdout []byte) []byte {
+	var out bytes.Buffer
+	var style ansiStyle
+
+	out.WriteString(`<pre class="ansi">`)
+
+	write := func(text string) {
+		if text == "" {
+			return
+		}
+
+		classes := style.classes()
+		if classes == "" {
+			out.WriteString(html.EscapeString(text))
+			return
+		}
+
+		fmt.Fprintf(&out, `<span class="%s">%s</span>`, classes, html.EscapeString(text))
+	}
+
+	text := string(stdout)
+	for {
+		loc := escapeSequence.FindStringSubmatchIndex(text)
+		if loc == nil {
+			write(text)
+			break
+		}
+
+		write(text[:loc[0]])
+
+		if text[loc[4]:loc[5]] == "m" {
+			style = applySGR(style, text[loc[2]:loc[3]])
+		}
+
+		text = text[loc[1]:]
+	}
+
+	out.WriteString("</pre>")
+	return out.Bytes()
+}
+
+func applySGR(style ansiStyle, params string) ansiStyle {
+	codes := strings.Split(params, ";")
+
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			code = 0
+		}
+
+		switch {
+		case code == 0:
+			style = ansiStyle{}
+		case code == 1:
+			style.bold = true
+		case code == 3:
+			style.italic = true
+		case code == 4:
+			style.underline = true
+		case code == 22:
+			style.bold = false
+		case code == 23:
+			style.italic = false
+		case code == 24:
+			style.underline = false
+		case code >= 30 && code <= 37:
+			style.fg = strconv.Itoa(code - 30)
+		case code == 39:
+			style.fg = ""
+		case code >= 40 && code <= 47:
+			style.bg = strconv.Itoa(code - 40)
+		case code == 49:
+			style.bg = ""
+		case code >= 90 && code <= 97:
+			style.fg = strconv.Itoa(code - 90 + 8)
+		case code >= 100 && code <= 107:
+			style.bg = strconv.Itoa(code - 100 + 8)
+		case code == 38 || code == 48:
+			// Extended colors are not supported. Skip their arguments.
+			if i+1 < len(codes) && codes[i+1] == "5" {
+				i += 2
+			} else if i+1 < len(codes) && codes[i+1] == "2" {
+				i += 4
+			}
+		}
+	}
+
+	return style
+}
@@ -0,0 +1,147 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	heading     = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletItem  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	orderedItem = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	codeSpan    = regexp.MustCompile("`([^`]+)`")
+	strong      = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	emphasis    = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	link        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	safeURL     = regexp.MustCompile(`^(https?:|mailto:|/|#)`)
+)
+
+// Markdown converts the commonly used subset of markdown to HTML: headings,
+// paragraphs, lists, block quotes, fenced code, and inline code, emphasis and
+// links. Everything else is treated as text.
+func Markdown(stdout []byte) []byte {
+	var out bytes.Buffer
+	var paragraph []string
+	list := ""
+	inCode := false
+
+	closeParagraph := func() {
+		if len(paragraph) > 0 {
+			fmt.Fprintf(&out, "<p>%s</p>\n", inline(strings.Join(paragraph, "\n")))
+			paragraph = nil
+		}
+	}
+
+	closeList := func() {
+		if list != "" {
+			fmt.Fprintf(&out, "</%s>\n", list)
+			list = ""
+		}
+	}
+
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			fmt.Fprintf(&out, "<%s>\n", tag)
+			list = tag
+		}
+	}
+
+	for line := range strings.Lines(string(stdout)) {
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				out.WriteString("</code></pre>\n")
+			} else {
+				closeParagraph()
+				closeList()
+				out.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+
+		if inCode {
+			out.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			closeParagraph()
+			closeList()
+			continue
+		}
+
+		if m := heading.FindStringSubmatch(line); m != nil {
+			closeParagraph()
+			closeList()
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", len(m[1]), inline(m[2]), len(m[1]))
+			continue
+		}
+
+		if m := bulletItem.FindStringSubmatch(line); m != nil {
+			closeParagraph()
+			openList("ul")
+			fmt.Fprintf(&out, "<li>%s</li>\n", inline(m[1]))
+			continue
+		}
+
+		if m := orderedItem.FindStringSubmatch(line); m != nil {
+			closeParagraph()
+			openList("ol")
+			fmt.Fprintf(&out, "<li>%s</li>\n", inline(m[1]))
+			continue
+		}
+
+		if quote, found := strings.CutPrefix(line, ">"); found {
+			closeParagraph()
+			closeList()
+			fmt.Fprintf(&out, "<blockquote>%s</blockquote>\n", inline(strings.TrimSpace(quote)))
+			continue
+		}
+
+		closeList()
+		paragraph = append(paragraph, line)
+	}
+
+	if inCode {
+		out.WriteString("</code></pre>\n")
+	}
+
+	closeParagraph()
+	closeList()
+
+	return out.Bytes()
+}
+
+func inline(text string) string {
+	text = html.EscapeString(text)
+
+	// Code spans are set aside so their content isn't formatted.
+	var spans []string
+	text = codeSpan.ReplaceAllStringFunc(text, func(m string) string {
+		spans = append(spans, "<code>"+codeSpan.FindStringSubmatch(m)[1]+"</code>")
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	})
+
+	text = link.ReplaceAllStringFunc(text, func(m string) string {
+		parts := link.FindStringSubmatch(m)
+		if !safeURL.MatchString(html.UnescapeString(parts[2])) {
+			return parts[1]
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, parts[2], parts[1])
+	})
+
+	text = strong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = emphasis.ReplaceAllString(text, "<em>$1$2</em>")
+
+	for i, span := range spans {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", i), span, 1)
+	}
+
+	return text
+}
@@ -0,0 +1,32 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+var Formats = []string{"text", "html", "markdown", "json", "ansi"}
+
+// Render converts command output to a format the browser can display and
+// returns it along with its content type. HTML produced from markdown and
+// ANSI output is escaped, but html output is passed through as-is and is
+// sanitized by the browser before display.
+func Render(format string, stdout []byte) ([]byte, string) {
+	switch format {
+	case "html":
+		return stdout, "text/html"
+	case "markdown":
+		return Markdown(stdout), "text/html"
+	case "ansi":
+		return ANSI(stdout), "text/html"
+	case "json":
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, stdout, "", "  "); err != nil {
+			return stdout, "text/plain"
+		}
+		indented.WriteByte('\n')
+		return indented.Bytes(), "application/json"
+	default:
+		return stdout, "text/plain"
+	}
+}
@@ -0,0 +1,79 @@
+package output
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format      string
+		stdout      string
+		contentType string
+		contains    string
+	}{
+		{format: "text", stdout: "a < b > c", contentType: "text/plain", contains: "a < b > c"},
+		{format: "html", stdout: "<b>hi</b>", contentType: "text/html", contains: "<b>hi</b>"},
+		{format: "markdown", stdout: "# Title", contentType: "text/html", contains: "<h1>Title</h1>"},
+		{format: "ansi", stdout: "plain", contentType: "text/html", contains: `<pre class="ansi">plain</pre>`},
+		{format: "json", stdout: `{"a":1}`, contentType: "application/json", contains: "{\n  \"a\": 1\n}"},
+		{format: "json", stdout: "not json", contentType: "text/plain", contains: "not json"},
+	}
+
+	for _, tt := range tests {
+		body, contentType := Render(tt.format, []byte(tt.stdout))
+
+		if contentType != tt.contentType {
+			t.Errorf("Expected %s for %s, got %s", tt.contentType, tt.format, contentType)
+		}
+
+		if !strings.Contains(string(body), tt.contains) {
+			t.Errorf("Expected %s output to contain %q, got %q", tt.format, tt.contains, body)
+		}
+	}
+}
+
+func TestANSI(t *testing.T) {
+	tests := []struct {
+		stdout   string
+		expected string
+	}{
+		{stdout: "\x1b[1;31mfail\x1b[0m ok", expected: `<pre class="ansi"><span class="ansi-bold ansi-fg-1">fail</span> ok</pre>`},
+		{stdout: "\x1b[92mbright\x1b[39m", expected: `<pre class="ansi"><span class="ansi-fg-10">bright</span></pre>`},
+		{stdout: "\x1b[2K<script>", expected: `<pre class="ansi">&lt;script&gt;</pre>`},
+		{stdout: "\x1b[38;5;200;4mx", expected: `<pre class="ansi"><span class="ansi-underline">x</span></pre>`},
+	}
+
+	for _, tt := range tests {
+		html := string(ANSI([]byte(tt.stdout)))
+		if html != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, html)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		stdout   string
+		expected string
+	}{
+		{stdout: "- one\n- two", expected: "<ul>\n<li>one</li>\n<li>two</li>\n</ul>"},
+		{stdout: "1. one\n2. two", expected: "<ol>\n<li>one</li>\n<li>two</li>\n</ol>"},
+		{stdout: "**bold** and *em*", expected: "<strong>bold</strong> and <em>em</em>"},
+		{stdout: "`<b>`", expected: "<code>&lt;b&gt;</code>"},
+		{stdout: "```\n**x** <y>\n```", expected: "<pre><code>**x** &lt;y&gt;\n</code></pre>"},
+		{stdout: "[home](https://example.com)", expected: `<a href="https://example.com">home</a>`},
+		{stdout: "[bad](javascript:alert(1))", expected: "bad"},
+		{stdout: "<img src=x onerror=alert(1)>", expected: "&lt;img src=x onerror=alert(1)&gt;"},
+	}
+
+	for _, tt := range tests {
+		html := string(Markdown([]byte(tt.stdout)))
+		if !strings.Contains(html, tt.expected) {
+			t.Errorf("Expected %q in markdown output, got %q", tt.expected, html)
+		}
+		if strings.Contains(html, "javascript:") {
+			t.Errorf("Unsafe link was not dropped: %q", html)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{spec: "every 15m", valid: true},
+		{spec: "every 6h", valid: true},
+		{spec: "every 1ms", valid: false},
+		{spec: "every soon", valid: false},
+		{spec: "0 23 * * *", valid: true},
+		{spec: "*/5 9-17 * * 1-5", valid: true},
+		{spec: "0 0 1,15 * 7", valid: true},
+		{spec: "@daily", valid: true},
+		{spec: "60 * * * *", valid: false},
+		{spec: "* * *", valid: false},
+		{spec: "", valid: false},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+		if tt.valid && err != nil {
+			t.Errorf("Valid schedule %q was rejected: %v", tt.spec, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("Invalid schedule %q was accepted", tt.spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	after := time.Date(2026, time.March, 6, 22, 30, 15, 0, time.UTC) // A Friday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "every 15m", want: time.Date(2026, time.March, 6, 22, 45, 0, 0, time.UTC)},
+		{spec: "0 23 * * *", want: time.Date(2026, time.March, 6, 23, 0, 0, 0, time.UTC)},
+		{spec: "*/20 * * * *", want: time.Date(2026, time.March, 6, 22, 40, 0, 0, time.UTC)},
+		{spec: "0 9 * * 1-5", want: time.Date(2026, time.March, 9, 9, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 * *", want: time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 15 * 6", want: time.Date(2026, time.March, 7, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 30 2 *", want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		schedule, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := schedule.Next(after)
+		if !got.Equal(tt.want) {
+			t.Errorf("Next run for %q: wanted %s, got %s", tt.spec, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"log"
+	"time"
+)
+
+// Job is a key that runs on a schedule.
+type Job struct {
+	Name string
+	Spec string
+}
+
+type entry struct {
+	spec string
+	next time.Time
+}
+
+// Run calls fire with the name of each job when it is due, each in a
+// goroutine of its own so that a long run doesn't hold up the others. It
+// doesn't return.
+func Run(jobs func() []Job, fire func(name string)) {
+	entries := make(map[string]entry)
+
+	for {
+		now := time.Now()
+		wake := now.Add(time.Minute)
+
+		for name, e := range plan(jobs(), entries, now) {
+			if e.next.IsZero() {
+				continue
+			}
+
+			if !e.next.After(now) {
+				go fire(name)
+				e.next = nextRun(e.spec, now)
+				entries[name] = e
+			}
+
+			if e.next.Before(wake) {
+				wake = e.next
+			}
+		}
+
+		time.Sleep(time.Until(wake))
+	}
+}
+
+// The keymap can change while the scheduler is running, so entries are
+// reconciled against the jobs on every pass.
+func plan(jobs []Job, entries map[string]entry, now time.Time) map[string]entry {
+	seen := make(map[string]bool)
+
+	for _, job := range jobs {
+		seen[job.Name] = true
+
+		if e, found := entries[job.Name]; found && e.spec == job.Spec {
+			continue
+		}
+
+		next := nextRun(job.Spec, now)
+		if next.IsZero() {
+			log.Printf("Ignoring invalid schedule for %s: %s", job.Name, job.Spec)
+		}
+
+		entries[job.Name] = entry{job.Spec, next}
+	}
+
+	for name := range entries {
+		if !seen[name] {
+			delete(entries, name)
+		}
+	}
+
+	return entries
+}
+
+func nextRun(spec string, after time.Time) time.Time {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return schedule.Next(after)
+}
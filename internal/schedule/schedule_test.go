@@ -0,0 +1,31 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2026, time.March, 6, 22, 30, 0, 0, time.UTC)
+	entries := make(map[string]entry)
+
+	plan([]Job{{"test", "every 1h"}, {"test2", "every now and then"}}, entries, now)
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	if want := now.Add(30 * time.Minute); !entries["test"].next.Equal(want) {
+		t.Errorf("Expected next run at %s, got %s", want, entries["test"].next)
+	}
+
+	if !entries["test2"].next.IsZero() {
+		t.Errorf("Invalid schedule should not have a next run")
+	}
+
+	plan(nil, entries, now)
+
+	if len(entries) != 0 {
+		t.Errorf("Entries for removed keys were not dropped")
+	}
+}
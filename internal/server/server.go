@@ -2,25 +2,50 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	htmltemplate "html/template"
 	"keys/internal/asset"
 	"keys/internal/config"
+	"keys/internal/diff"
+	"keys/internal/export"
+	"keys/internal/job"
 	"keys/internal/keymap"
+	"keys/internal/notify"
+	"keys/internal/output"
+	"keys/internal/schedule"
 	"keys/internal/sound"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	texttemplate "text/template"
 	"time"
 )
 
+var triggerSources = []string{"api", "browser", "keyboard", "schedule"}
+
+// internalSources can only be claimed by requests that carry the trigger
+// token of the config.
+var internalSources = []string{"keyboard", "schedule"}
+
+// reservedTriggerParams are query parameters of POST /trigger/{key} that
+// aren't passed to the command.
+var reservedTriggerParams = []string{"source", "async", "confirm"}
+
 type Server struct {
 	ServerAddress string
 	Config        *config.Config
 }
 
-func Serve(cfg *config.Config, port int) {
+// Serve handles requests on the port. Scheduled keys are run once it is
+// listening, since they are triggered through it.
+func Serve(cfg *config.Config, port int, scheduled bool) {
 	s := Server{
 		ServerAddress: fmt.Sprintf(":%d", port),
 		Config:        cfg,
@@ -33,12 +58,27 @@ func Serve(cfg *config.Config, port int) {
 	mux.HandleFunc("GET /assets/keys.css", s.assetHandler)
 	mux.HandleFunc("GET /assets/keys.js", s.assetHandler)
 	mux.HandleFunc("GET /edit", s.editHandler)
+	mux.HandleFunc("GET /export", s.exportHandler)
 	mux.HandleFunc("GET /openapi.yaml", s.openapiHandler)
 	mux.HandleFunc("GET /version", s.versionHandler)
 	mux.HandleFunc("POST /edit", s.saveHandler)
+	mux.HandleFunc("GET /edit/history", s.historyHandler)
+	mux.HandleFunc("POST /edit/history/{id}", s.restoreHandler)
+	mux.HandleFunc("GET /keys/{name}", s.getKeyHandler)
+	mux.HandleFunc("POST /keys/{name}", s.addKeyHandler)
+	mux.HandleFunc("PUT /keys/{name}", s.updateKeyHandler)
+	mux.HandleFunc("DELETE /keys/{name}", s.deleteKeyHandler)
 	mux.HandleFunc("POST /trigger/{key}", s.triggerHandler)
+	mux.HandleFunc("GET /state/{key}", s.stateHandler)
+	mux.HandleFunc("GET /running", s.runningHandler)
+	mux.HandleFunc("DELETE /running/{id}", s.cancelHandler)
+	mux.HandleFunc("GET /jobs/{id}", s.jobHandler)
+	mux.HandleFunc("GET /sound", s.soundHandler)
+	mux.HandleFunc("POST /sound/{action}", s.muteHandler)
+	mux.HandleFunc("POST /settings/sound", s.soundSettingHandler)
+	mux.HandleFunc("POST /settings/layout", s.layoutSettingHandler)
 	mux.HandleFunc("GET /util/keys.sh", s.shellHandler)
-	log.Printf("Serving on %s and available from %s", s.ServerAddress, cfg.PublicUrl)
+	log.Printf("Serving on %s and available from %s", s.ServerAddress, cfg.PublicUrl())
 	log.Printf("Config file is %s", cfg.Keymap.Filename)
 
 	server := &http.Server{
@@ -49,7 +89,49 @@ func Serve(cfg *config.Config, port int) {
 		IdleTimeout:  15 * time.Second,
 	}
 
-	log.Fatal(server.ListenAndServe())
+	cfg.ConfigureSound()
+	cfg.Keymap.OnLoad = cfg.ConfigureSound
+
+	listener, err := net.Listen("tcp", s.ServerAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if scheduled {
+		go schedule.Run(cfg.Keymap.ScheduledKeys, s.runScheduled)
+	}
+
+	log.Fatal(server.Serve(listener))
+}
+
+func (s *Server) runScheduled(name string) {
+	key := s.Config.Keymap.FindKeyByName(name)
+	if key == nil {
+		return
+	}
+
+	if s.Config.KeyboardLocked() && !key.ScheduleWhenLocked {
+		log.Printf("Skipping scheduled run of %s because the keyboard is locked", name)
+		return
+	}
+
+	url := fmt.Sprintf("%s/trigger/%s?source=schedule", s.Config.TriggerUrl, url.PathEscape(name))
+
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		log.Printf("Error during scheduled run of %s: %s", name, err)
+		return
+	}
+	req.Header.Set(config.TokenHeader, s.Config.TriggerToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error during scheduled run of %s: %s", name, err)
+		return
+	}
+	defer res.Body.Close()
+
+	log.Printf("Scheduled POST to %s returned %d", url, res.StatusCode)
 }
 
 func requestLogger(next http.Handler) http.Handler {
@@ -69,8 +151,8 @@ func serverHeaders(next http.Handler, config *config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("X-Forwarded-Proto") != "" && r.Header.Get("X-Forwarded-Host") != "" {
 			forwardedPublicUrl := fmt.Sprintf("%s://%s", r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-Host"))
-			if forwardedPublicUrl != config.PublicUrl {
-				config.PublicUrl = forwardedPublicUrl
+			if forwardedPublicUrl != config.PublicUrl() {
+				config.SetPublicUrl(forwardedPublicUrl)
 			}
 		}
 
@@ -119,7 +201,7 @@ func (s *Server) keymapTextWriter(w http.ResponseWriter, r *http.Request) {
 	physicalKey := strings.ToLower(query.Get("key"))
 
 	funcMap := texttemplate.FuncMap{
-		"queryMatch": func(k keymap.Key) bool {
+		"queryMatch": func(k *keymap.Key) bool {
 			if name != "" && !k.MatchesName(name) {
 				return false
 			}
@@ -191,7 +273,7 @@ func (s *Server) shellHandler(w http.ResponseWriter, r *http.Request) {
 		PublicUrl string
 		Version   string
 	}{
-		PublicUrl: s.Config.PublicUrl,
+		PublicUrl: s.Config.PublicUrl(),
 		Version:   strings.TrimSpace(string(asset.ReadVersion())),
 	}
 
@@ -237,16 +319,74 @@ func (s *Server) editHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	path, ok := s.configFile(w, r.URL.Query().Get("file"))
+	if !ok {
+		return
+	}
+
+	raw := s.Config.Keymap.ReadFile(path)
+	hash := keymap.Hash(raw)
+
+	w.Header().Set("ETag", `"`+hash+`"`)
+	s.editorWriter(w, http.StatusOK, editorPage{Path: path, Raw: raw, Hash: hash, Diagnostics: s.Config.Keymap.CheckEdit(path, raw)})
+}
+
+// configFile finds the config file chosen by name, or the main one if none
+// is, and responds with 404 if there is no such file.
+func (s *Server) configFile(w http.ResponseWriter, name string) (string, bool) {
+	if name == "" {
+		return s.Config.Keymap.Filename, true
+	}
+
+	path, err := s.Config.Keymap.File(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return "", false
+	}
+
+	return path, true
+}
+
+// editorPage is what the editor shows for one of the config files. Raw is
+// not the saved file when a save was refused, and Hash is of the version it
+// was based on.
+type editorPage struct {
+	Path        string
+	File        string
+	Files       []string
+	Raw         []byte
+	Hash        string
+	Sections    []keymap.KeySection
+	Diagnostics []keymap.Diagnostic
+	Conflict    []diff.Hunk
+}
+
+func (s *Server) editorWriter(w http.ResponseWriter, status int, page editorPage) {
 	templates := htmltemplate.Must(htmltemplate.ParseFS(asset.AssetFS, "assets/layout.html", "assets/editor.html"))
 
+	km := s.Config.Keymap
+	page.File = km.FileName(page.Path)
+	for _, path := range km.Files() {
+		page.Files = append(page.Files, km.FileName(path))
+	}
+
+	page.Sections = []keymap.KeySection{}
+	for _, ks := range km.Sections() {
+		if ks.File == page.File {
+			page.Sections = append(page.Sections, ks)
+		}
+	}
+
 	var output bytes.Buffer
 
-	if err := templates.ExecuteTemplate(&output, "layout.html", s.Config.Keymap); err != nil {
+	if err := templates.ExecuteTemplate(&output, "layout.html", page); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if _, err := w.Write([]byte(err.Error())); err != nil {
 			log.Fatalf("unable to write edit response error body: %v", err)
 		}
 	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
 		if _, err := w.Write(output.Bytes()); err != nil {
 			log.Fatalf("unable to write edit response body: %v", err)
 		}
@@ -266,8 +406,42 @@ func (s *Server) saveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	path, ok := s.configFile(w, r.Form.Get("file"))
+	if !ok {
+		return
+	}
+
 	content := []byte(r.Form.Get("content"))
-	err = s.Config.Keymap.Replace(content)
+
+	// The hash of the config the edit started from comes from the editor
+	// form, or an If-Match header for other clients. Without either the
+	// config is replaced regardless.
+	hash := r.Form.Get("hash")
+	if hash == "" {
+		hash = strings.Trim(r.Header.Get("If-Match"), `"`)
+	}
+
+	if diagnostics := s.Config.Keymap.CheckEdit(path, content); keymap.HasErrors(diagnostics) {
+		s.editorWriter(w, http.StatusUnprocessableEntity, editorPage{Path: path, Raw: content, Hash: hash, Diagnostics: diagnostics})
+		return
+	}
+
+	err = s.Config.Keymap.ReplaceIfUnchanged(path, hash, content)
+	if errors.Is(err, keymap.ErrStale) {
+		saved := s.Config.Keymap.ReadFile(path)
+		if bytes.Equal(saved, content) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		s.editorWriter(w, http.StatusConflict, editorPage{
+			Path:     path,
+			Raw:      content,
+			Hash:     keymap.Hash(saved),
+			Conflict: diff.Hunks(saved, content, 3),
+		})
+		return
+	}
 	if err != nil {
 		wrappedError := fmt.Errorf("error during save: %w", err)
 		http.Error(w, wrappedError.Error(), http.StatusInternalServerError)
@@ -277,6 +451,196 @@ func (s *Server) saveHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.acceptableRequest(w, r, []string{"text/html"}) {
+		return
+	}
+
+	name := r.URL.Query().Get("file")
+	path, ok := s.configFile(w, name)
+	if !ok {
+		return
+	}
+
+	revisions, err := s.Config.Keymap.Revisions(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error during history lookup: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	type revisionView struct {
+		keymap.Revision
+		Current bool
+		Hunks   []diff.Hunk
+	}
+
+	current := s.Config.Keymap.ReadFile(path)
+	views := make([]revisionView, len(revisions))
+
+	// Each revision is compared with the one before it, which is next in
+	// the list since the newest comes first.
+	var older []byte
+	for i := len(revisions) - 1; i >= 0; i-- {
+		content, err := s.Config.Keymap.ReadRevision(path, revisions[i].ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error during history lookup: %s", err), http.StatusInternalServerError)
+			return
+		}
+
+		views[i] = revisionView{Revision: revisions[i], Current: bytes.Equal(content, current)}
+		if i < len(revisions)-1 {
+			views[i].Hunks = diff.Hunks(older, content, 3)
+		}
+		older = content
+	}
+
+	templates := htmltemplate.Must(htmltemplate.ParseFS(asset.AssetFS, "assets/layout.html", "assets/history.html"))
+
+	templateVars := struct {
+		File      string
+		Revisions []revisionView
+	}{
+		File:      name,
+		Revisions: views,
+	}
+
+	var output bytes.Buffer
+	if err := templates.ExecuteTemplate(&output, "layout.html", templateVars); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := w.Write([]byte(err.Error())); err != nil {
+			log.Fatalf("unable to write history response error body: %v", err)
+		}
+	} else {
+		if _, err := w.Write(output.Bytes()); err != nil {
+			log.Fatalf("unable to write history response body: %v", err)
+		}
+	}
+}
+
+func (s *Server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	name := r.URL.Query().Get("file")
+	path, ok := s.configFile(w, name)
+	if !ok {
+		return
+	}
+
+	err := s.Config.Keymap.Restore(path, id)
+	if errors.Is(err, keymap.ErrRevisionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error during restore: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Restored %s from %s", s.Config.Keymap.FileName(path), id)
+	target := "/edit/history"
+	if name != "" {
+		target += "?" + url.Values{"file": {name}}.Encode()
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func (s *Server) getKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ks, err := s.Config.Keymap.Section(r.PathValue("name"))
+	if err != nil {
+		s.keyErrorWriter(w, err)
+		return
+	}
+
+	s.jsonWriter(w, http.StatusOK, ks)
+}
+
+func (s *Server) addKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ks, ok := s.readKeySection(w, r)
+	if !ok {
+		return
+	}
+
+	ks.Name = r.PathValue("name")
+	if err := s.Config.Keymap.AddKey(ks); err != nil {
+		s.keyErrorWriter(w, err)
+		return
+	}
+
+	log.Printf("Added %s to the keymap", ks.Name)
+	s.sectionWriter(w, http.StatusCreated, ks.Name)
+}
+
+func (s *Server) updateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ks, ok := s.readKeySection(w, r)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	if ks.Name == "" {
+		ks.Name = name
+	}
+
+	if err := s.Config.Keymap.UpdateKey(name, ks); err != nil {
+		s.keyErrorWriter(w, err)
+		return
+	}
+
+	log.Printf("Updated %s in the keymap", ks.Name)
+	s.sectionWriter(w, http.StatusOK, ks.Name)
+}
+
+func (s *Server) deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.Config.Keymap.DeleteKey(r.PathValue("name")); err != nil {
+		s.keyErrorWriter(w, err)
+		return
+	}
+
+	log.Printf("Removed %s from the keymap", r.PathValue("name"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) readKeySection(w http.ResponseWriter, r *http.Request) (keymap.KeySection, bool) {
+	const maxBodySize = 100000
+
+	var ks keymap.KeySection
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := decoder.Decode(&ks); err != nil {
+		http.Error(w, fmt.Sprintf("error during request parsing: %s", err), http.StatusBadRequest)
+		return ks, false
+	}
+
+	return ks, true
+}
+
+// sectionWriter responds with a section as it was saved.
+func (s *Server) sectionWriter(w http.ResponseWriter, status int, name string) {
+	ks, err := s.Config.Keymap.Section(name)
+	if err != nil {
+		s.keyErrorWriter(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/keys/"+url.PathEscape(name))
+	s.jsonWriter(w, status, ks)
+}
+
+func (s *Server) keyErrorWriter(w http.ResponseWriter, err error) {
+	var validationErr *keymap.ValidationError
+
+	switch {
+	case errors.As(err, &validationErr):
+		s.jsonWriter(w, http.StatusUnprocessableEntity, validationErr)
+	case errors.Is(err, keymap.ErrKeyNotFound), errors.Is(err, keymap.ErrFileNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, keymap.ErrKeyExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fmt.Sprintf("error during save: %s", err), http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) maybePlaySound(name sound.Name) {
 	if !s.Config.Keymap.SoundAllowed {
 		return
@@ -287,6 +651,118 @@ func (s *Server) maybePlaySound(name sound.Name) {
 	}
 }
 
+// Keys with a sound of their own play it in place of the usual one.
+func (s *Server) maybePlayKeySound(key *keymap.Key, fallback sound.Name) {
+	if key.Sound == "" {
+		s.maybePlaySound(fallback)
+		return
+	}
+
+	if !s.Config.Keymap.SoundAllowed {
+		return
+	}
+
+	if err := sound.PlayFile(key.Sound, key.SoundVolume); err != nil {
+		log.Println(err)
+		s.maybePlaySound(fallback)
+	}
+}
+
+func (s *Server) maybeSpeak(key *keymap.Key, stdout []byte, failed bool) {
+	if !s.Config.Keymap.SoundAllowed {
+		return
+	}
+
+	text := key.SpokenFeedback(s.Config.Keymap.Speech, stdout, failed)
+	if text == "" {
+		return
+	}
+
+	go func() {
+		if err := sound.Say(text); err != nil {
+			log.Printf("Unable to speak feedback for %s: %v", key.Name, err)
+		}
+	}()
+}
+
+func (s *Server) maybeNotify(key *keymap.Key, failed bool, body string) {
+	if !key.ShouldNotify(s.Config.Keymap.Notify, failed) {
+		return
+	}
+
+	summary := key.Name
+	if key.CanToggle() {
+		summary = fmt.Sprintf("%s (%s)", key.Name, key.State())
+	}
+
+	notification := notify.Notification{
+		Tag:      key.Name,
+		Summary:  summary,
+		Body:     body,
+		Critical: failed,
+	}
+
+	go func() {
+		if err := s.Config.Notifier.Send(notification); err != nil {
+			log.Printf("Unable to send notification for %s: %v", key.Name, err)
+		}
+	}()
+}
+
+// triggerParams are the query parameters and form fields of a trigger
+// request that are for the command rather than the server.
+func triggerParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	if err := r.ParseForm(); err != nil {
+		log.Println(err)
+	}
+
+	for name, values := range r.Form {
+		if !slices.Contains(reservedTriggerParams, name) && len(values) > 0 {
+			params[name] = values[0]
+		}
+	}
+
+	return params
+}
+
+// Keyboard presses are confirmed by pressing the same key again within a few
+// seconds, and scheduled runs were confirmed by writing the schedule.
+// Everything else has to ask for confirmation explicitly.
+func (s *Server) confirmed(key *keymap.Key, r *http.Request, source string) bool {
+	if r.URL.Query().Get("confirm") == "1" || source == "schedule" {
+		return true
+	}
+
+	if source != "keyboard" {
+		return false
+	}
+
+	if key.Confirm() {
+		return true
+	}
+
+	s.maybePlaySound(sound.Prompt)
+	return false
+}
+
+// triggerSource is where a trigger request came from. Any client can ask
+// for the browser source, but only the keyboard listener and the scheduler
+// know the token that goes with theirs.
+func (s *Server) triggerSource(r *http.Request) string {
+	source := r.URL.Query().Get("source")
+	if !slices.Contains(triggerSources, source) {
+		return "api"
+	}
+
+	token := r.Header.Get(config.TokenHeader)
+	if slices.Contains(internalSources, source) && subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.TriggerToken)) != 1 {
+		return "api"
+	}
+
+	return source
+}
+
 func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
 	key := s.Config.Keymap.FindKey(r.PathValue("key"))
 
@@ -300,32 +776,101 @@ func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	source := s.triggerSource(r)
+
+	log.Printf("Triggering %s from %s", key.Name, source)
+
+	params, err := key.ResolveParams(triggerParams(r))
+	if err != nil {
+		s.maybePlaySound(sound.Error)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := key.RunProbeContext(r.Context()); err != nil {
+		log.Println(err)
+	}
+
+	if key.RequireConfirm && !s.confirmed(key, r, source) {
+		http.Error(w, fmt.Sprintf("%s requires confirmation. Repeat the request with confirm=1 to run it.", key.Name), http.StatusConflict)
+		return
+	}
+
 	var stdout []byte
-	var err error
+	contentType := "text/plain"
 	switch key.CurrentCommand() {
 	case "lock":
-		s.maybePlaySound(sound.Lock)
-		s.Config.KeyboardLocked = true
+		s.maybePlayKeySound(key, sound.Lock)
+		s.Config.SetKeyboardLocked(true)
 		key.Toggle()
 		stdout = []byte("Keyboard locked")
+		s.maybeNotify(key, false, string(stdout))
+		s.maybeSpeak(key, stdout, false)
 		w.Header().Set("X-Keys-Locked", "1")
 	case "unlock":
-		s.maybePlaySound(sound.Unlock)
-		s.Config.KeyboardLocked = false
+		s.maybePlayKeySound(key, sound.Unlock)
+		s.Config.SetKeyboardLocked(false)
 		key.Toggle()
 		stdout = []byte("Keyboard unlocked")
+		s.maybeNotify(key, false, string(stdout))
+		s.maybeSpeak(key, stdout, false)
 		w.Header().Set("X-Keys-Locked", "0")
+	case "mute":
+		key.Toggle()
+		if err := s.setSound(false); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stdout = []byte("Sound off")
+		w.Header().Set("X-Keys-Sound", "0")
+	case "unmute":
+		key.Toggle()
+		if err := s.setSound(true); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.maybePlayKeySound(key, sound.Confirmation)
+		stdout = []byte("Sound on")
+		w.Header().Set("X-Keys-Sound", "1")
 	default:
-		stdout, err = key.RunCommand()
-		if err != nil {
+		release, throttleErr := s.Config.Limiter.Acquire(key, s.Config.Keymap.MaxConcurrent)
+		if throttleErr != nil {
 			s.maybePlaySound(sound.Error)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("%s was not run: %s", key.Name, throttleErr), http.StatusTooManyRequests)
 			return
 		}
 
-		if key.Confirmation {
-			s.maybePlaySound(sound.Confirmation)
+		j, ctx := s.Config.Jobs.Start(key.Name, source)
+
+		if r.URL.Query().Get("async") == "1" {
+			go func() {
+				defer release()
+				s.execute(ctx, key, j, source, params)
+			}()
+
+			snapshot, _ := s.Config.Jobs.Get(j.ID)
+			w.Header().Set("Location", "/jobs/"+j.ID)
+			s.jsonWriter(w, http.StatusAccepted, snapshot)
+			return
+		}
+
+		result := s.execute(ctx, key, j, source, params)
+		release()
+
+		switch result.Status {
+		case job.Cancelled:
+			http.Error(w, result.Err.Error(), http.StatusConflict)
+			return
+		case job.TimedOut:
+			http.Error(w, result.Err.Error(), http.StatusGatewayTimeout)
+			return
+		case job.Failed:
+			http.Error(w, result.Err.Error(), http.StatusInternalServerError)
+			return
 		}
+
+		stdout = result.Output
+		contentType = result.ContentType
 	}
 
 	if key.CanToggle() {
@@ -337,11 +882,7 @@ func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if bytes.ContainsRune(stdout, '<') && bytes.ContainsRune(stdout, '>') {
-		w.Header().Set("Content-Type", "text/html")
-	} else {
-		w.Header().Set("Content-Type", "text/plain")
-	}
+	w.Header().Set("Content-Type", contentType)
 
 	// #nosec G705 # because stdout comes from the command specified in the configuration
 	if _, err := w.Write(stdout); err != nil {
@@ -349,6 +890,219 @@ func (s *Server) triggerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) execute(ctx context.Context, key *keymap.Key, j *job.Job, source string, params map[string]string) job.Result {
+	stdout, err := key.RunCommandContext(ctx, source, params)
+
+	result := job.Result{
+		Status: job.Succeeded,
+		Err:    err,
+		State:  key.State(),
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		result.Status = job.Cancelled
+		result.Err = fmt.Errorf("%s was cancelled", key.Name)
+	case errors.Is(err, keymap.ErrTimeout):
+		result.Status = job.TimedOut
+	case err != nil:
+		result.Status = job.Failed
+	}
+
+	if key.ShowOutput && len(stdout) > 0 {
+		result.Output, result.ContentType = output.Render(key.OutputFormat, stdout)
+	}
+
+	if result.Status != job.Succeeded {
+		s.maybePlaySound(sound.Error)
+		s.maybeNotify(key, true, result.Err.Error())
+		s.maybeSpeak(key, nil, true)
+	} else {
+		if key.Confirmation {
+			s.maybePlayKeySound(key, sound.Confirmation)
+		}
+		s.maybeNotify(key, false, string(stdout))
+		s.maybeSpeak(key, stdout, false)
+	}
+
+	s.Config.Jobs.Finish(j, result)
+	return result
+}
+
+func (s *Server) jsonWriter(w http.ResponseWriter, status int, v any) {
+	output, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(output); err != nil {
+		log.Fatalf("unable to write json response body: %v", err)
+	}
+}
+
+func (s *Server) stateHandler(w http.ResponseWriter, r *http.Request) {
+	key := s.Config.Keymap.FindKey(r.PathValue("key"))
+
+	if key == nil || !key.CanToggle() {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := key.RunProbeContext(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Keys-State", key.State())
+	w.Header().Set("Content-Type", "text/plain")
+	if _, err := w.Write([]byte(key.State())); err != nil {
+		log.Fatalf("unable to write state response body: %v", err)
+	}
+}
+
+func (s *Server) runningHandler(w http.ResponseWriter, r *http.Request) {
+	s.jsonWriter(w, http.StatusOK, s.Config.Jobs.Running())
+}
+
+func (s *Server) jobHandler(w http.ResponseWriter, r *http.Request) {
+	j, found := s.Config.Jobs.Get(r.PathValue("id"))
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.jsonWriter(w, http.StatusOK, j)
+}
+
+func (s *Server) cancelHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.Config.Jobs.Cancel(r.PathValue("id")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setSound turns sound on or off and saves the change to the config file.
+func (s *Server) setSound(allowed bool) error {
+	s.Config.Keymap.SetSound(allowed)
+	if allowed {
+		sound.Unmute()
+	} else {
+		sound.Mute()
+	}
+
+	if err := s.Config.Keymap.WriteSettings(); err != nil {
+		return fmt.Errorf("unable to save sound setting: %w", err)
+	}
+
+	log.Printf("Sound allowed: %t", allowed)
+	return nil
+}
+
+func (s *Server) soundSettingHandler(w http.ResponseWriter, r *http.Request) {
+	var allowed bool
+	switch strings.ToLower(r.FormValue("sound")) {
+	case "on", "true", "1":
+		allowed = true
+	case "off", "false", "0":
+		allowed = false
+	default:
+		http.Error(w, "sound must be on or off", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.setSound(allowed); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if allowed {
+		w.Header().Set("X-Keys-Sound", "1")
+	} else {
+		w.Header().Set("X-Keys-Sound", "0")
+	}
+
+	s.jsonWriter(w, http.StatusOK, map[string]bool{"sound": allowed})
+}
+
+// layoutSettingHandler chooses the built-in layout the keymap page draws,
+// or none if the layout is empty, and saves it to the config file.
+func (s *Server) layoutSettingHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("layout")
+	if err := s.Config.Keymap.SetLayout(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Config.Keymap.WriteSettings(); err != nil {
+		http.Error(w, fmt.Sprintf("unable to save layout setting: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Layout: %q", name)
+	s.jsonWriter(w, http.StatusOK, map[string]string{"layout": name})
+}
+
+func (s *Server) soundHandler(w http.ResponseWriter, r *http.Request) {
+	s.jsonWriter(w, http.StatusOK, sound.CurrentStatus())
+}
+
+// muteHandler changes the same sound setting as the settings page and the
+// mute and unmute commands.
+func (s *Server) muteHandler(w http.ResponseWriter, r *http.Request) {
+	var allowed bool
+	switch r.PathValue("action") {
+	case "mute":
+		allowed = false
+	case "unmute":
+		allowed = true
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.setSound(allowed); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.jsonWriter(w, http.StatusOK, sound.CurrentStatus())
+}
+
+// exportHandler renders the keymap as a cheat sheet to print.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "html-print"
+	}
+
+	grid, err := export.FindGrid(s.Config.Keymap, r.URL.Query().Get("grid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var output bytes.Buffer
+	if err := export.Write(&output, format, export.Sheets(s.Config.Keymap, grid), grid); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"keys%s\"", export.Extension(format)))
+	if format == "html-print" {
+		w.Header().Set("Content-Security-Policy", export.ContentSecurityPolicy())
+	}
+
+	if _, err := w.Write(output.Bytes()); err != nil {
+		log.Fatalf("unable to write export response body: %v", err)
+	}
+}
+
 func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	if _, err := w.Write(asset.ReadVersion()); err != nil {
@@ -372,7 +1126,7 @@ func (s *Server) openapiHandler(w http.ResponseWriter, r *http.Request) {
 		PublicUrl string
 		Version   string
 	}{
-		PublicUrl: s.Config.PublicUrl,
+		PublicUrl: s.Config.PublicUrl(),
 		Version:   strings.TrimSpace(string(asset.ReadVersion())),
 	}
 
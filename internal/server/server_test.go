@@ -1,10 +1,14 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"keys/internal/asset"
 	"keys/internal/config"
+	"keys/internal/job"
+	"keys/internal/keymap"
+	"keys/internal/sound"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -12,7 +16,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func failIfServerError(t *testing.T, rr *httptest.ResponseRecorder) {
@@ -36,6 +42,12 @@ func tempFile(t *testing.T) *os.File {
 		t.Fatal(err)
 	}
 
+	t.Cleanup(func() {
+		if err := os.RemoveAll(keymap.HistoryDir(tempFile.Name())); err != nil {
+			t.Fatal(err)
+		}
+	})
+
 	return tempFile
 }
 
@@ -72,9 +84,39 @@ func TestVersionHandler(t *testing.T) {
 	}
 }
 
+func TestExportHandler(t *testing.T) {
+	tests := []struct {
+		query       string
+		code        int
+		contentType string
+	}{
+		{"", http.StatusOK, "text/html; charset=utf-8"},
+		{"format=pdf&grid=numpad", http.StatusOK, "application/pdf"},
+		{"format=svg&grid=3x3", http.StatusOK, "image/svg+xml"},
+		{"format=png", http.StatusBadRequest, "text/plain; charset=utf-8"},
+		{"grid=5x5", http.StatusBadRequest, "text/plain; charset=utf-8"},
+		{"grid=full", http.StatusBadRequest, "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		server := serverFixture(t, "key-multiple.ini")
+		req := httptest.NewRequest("GET", "/export?"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.exportHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code || rr.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.query, tt.code, tt.contentType, rr.Code, rr.Header().Get("Content-Type"))
+		}
+
+		if tt.query == "" && !strings.HasPrefix(rr.Header().Get("Content-Security-Policy"), "default-src 'none'; style-src 'sha256-") {
+			t.Errorf("expected the print page to allow only its own style, got %q", rr.Header().Get("Content-Security-Policy"))
+		}
+	}
+}
+
 func TestShellHandler(t *testing.T) {
 	server := serverFixture(t, "key-multiple.ini")
-	server.Config.PublicUrl = "https://example.com"
+	server.Config.SetPublicUrl("https://example.com")
 
 	req := httptest.NewRequest("GET", "/", nil)
 	rr := httptest.NewRecorder()
@@ -97,14 +139,14 @@ func TestShellHandler(t *testing.T) {
 	}
 
 	body := rr.Body.String()
-	if !strings.Contains(body, server.Config.PublicUrl) {
+	if !strings.Contains(body, server.Config.PublicUrl()) {
 		t.Errorf("response body did not contain publis url")
 	}
 }
 
 func TestOpenApiHandler(t *testing.T) {
 	server := serverFixture(t, "key-multiple.ini")
-	server.Config.PublicUrl = "https://example.com"
+	server.Config.SetPublicUrl("https://example.com")
 
 	req := httptest.NewRequest("GET", "/", nil)
 	rr := httptest.NewRecorder()
@@ -130,7 +172,7 @@ func TestOpenApiHandler(t *testing.T) {
 		name   string
 		search string
 	}{
-		{name: "public url", search: fmt.Sprintf("url: \"%s\"", server.Config.PublicUrl)},
+		{name: "public url", search: fmt.Sprintf("url: \"%s\"", server.Config.PublicUrl())},
 		{name: "version path", search: "/version:"},
 	}
 
@@ -279,7 +321,7 @@ func TestTriggerLock(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 	failIfServerError(t, rr)
 
-	if server.Config.KeyboardLocked != true {
+	if !server.Config.KeyboardLocked() {
 		t.Error("Keyboard was not locked")
 	}
 
@@ -298,7 +340,7 @@ func TestTriggerLock(t *testing.T) {
 	handler.ServeHTTP(rr2, req2)
 	failIfServerError(t, rr)
 
-	if server.Config.KeyboardLocked != false {
+	if server.Config.KeyboardLocked() {
 		t.Error("Keyboard was not unlocked")
 	}
 
@@ -313,6 +355,220 @@ func TestTriggerLock(t *testing.T) {
 
 }
 
+func TestTriggerMute(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	fixture, err := os.ReadFile("../../testdata/key-roll-mute.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tmpFile.Write(fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+	handler := http.HandlerFunc(server.triggerHandler)
+
+	tests := []struct {
+		header  string
+		body    string
+		allowed bool
+		saved   string
+	}{
+		{header: "0", body: "Sound off", allowed: false, saved: "sound = off"},
+		{header: "1", body: "Sound on", allowed: true, saved: "sound = on"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/trigger", nil)
+		req.SetPathValue("key", "test")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		failIfServerError(t, rr)
+
+		if rr.Header().Get("X-Keys-Sound") != tt.header {
+			t.Errorf("expected X-Keys-Sound %s, got '%s'", tt.header, rr.Header().Get("X-Keys-Sound"))
+		}
+
+		if rr.Body.String() != tt.body {
+			t.Errorf("Unexpected body from mute request: '%s'", rr.Body.String())
+		}
+
+		if server.Config.Keymap.SoundAllowed != tt.allowed {
+			t.Errorf("expected SoundAllowed %v", tt.allowed)
+		}
+
+		saved, err := os.ReadFile(tmpFile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(saved), tt.saved) {
+			t.Errorf("expected config file to contain '%s', got:\n%s", tt.saved, saved)
+		}
+	}
+}
+
+func TestSoundSetting(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("[temp]\ncommand = echo temp\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	tests := []struct {
+		value   string
+		code    int
+		allowed bool
+	}{
+		{value: "off", code: http.StatusOK, allowed: false},
+		{value: "on", code: http.StatusOK, allowed: true},
+		{value: "loud", code: http.StatusBadRequest, allowed: true},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{}
+		form.Set("sound", tt.value)
+
+		req := httptest.NewRequest("POST", "/settings/sound", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.soundSettingHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("expected %d for %s, got %d", tt.code, tt.value, rr.Code)
+		}
+
+		if server.Config.Keymap.SoundAllowed != tt.allowed {
+			t.Errorf("expected SoundAllowed %v after %s", tt.allowed, tt.value)
+		}
+	}
+
+	reloaded, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reloaded.Keymap.SoundAllowed {
+		t.Error("Sound setting was not saved")
+	}
+}
+
+func TestLayoutSetting(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("[temp]\nphysical_key = q\ncommand = echo temp\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	tests := []struct {
+		value  string
+		code   int
+		layout string
+	}{
+		{value: "4x4", code: http.StatusOK, layout: "4x4"},
+		{value: "piano", code: http.StatusBadRequest, layout: "4x4"},
+		{value: "", code: http.StatusOK, layout: ""},
+		{value: "numpad", code: http.StatusOK, layout: "numpad"},
+	}
+
+	for _, tt := range tests {
+		form := url.Values{}
+		form.Set("layout", tt.value)
+
+		req := httptest.NewRequest("POST", "/settings/layout", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.layoutSettingHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("expected %d for %q, got %d", tt.code, tt.value, rr.Code)
+		}
+
+		if name := server.Config.Keymap.Layout().Name; name != tt.layout {
+			t.Errorf("expected layout %q after %q, got %q", tt.layout, tt.value, name)
+		}
+	}
+
+	reloaded, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Keymap.Layout().Name != "numpad" {
+		t.Error("Layout setting was not saved")
+	}
+}
+
+func TestKeymapLayout(t *testing.T) {
+	server := serverFixture(t, "key-layout.ini")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.keymapHandler).ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`<a class="layout-key mapped" data-name="lights" href="/trigger/lights"`,
+		`<a class="layout-key" href="/edit?physical_key=s"`,
+		`data-x="1" data-y="1" data-w="2" data-h="1"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the keymap page", want)
+		}
+	}
+
+	if strings.Contains(body, `id="config-layout"`) {
+		t.Error("expected no layout choice when the config has a layout section")
+	}
+}
+
 func TestTriggerToggle(t *testing.T) {
 	t.Cleanup(resetLogger)
 	log.SetOutput(io.Discard)
@@ -486,3 +742,880 @@ physical_key = t2
 		t.Errorf("config was not reloaded after edit (new physical key not found)")
 	}
 }
+
+func TestKeySections(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("# Temporary\n[temp]\ncommand = echo temp\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	tests := []struct {
+		method  string
+		name    string
+		body    string
+		handler http.HandlerFunc
+		code    int
+	}{
+		{"GET", "temp", "", server.getKeyHandler, http.StatusOK},
+		{"GET", "missing", "", server.getKeyHandler, http.StatusNotFound},
+		{"POST", "new", `{"options": [{"name": "command", "value": "echo new"}]}`, server.addKeyHandler, http.StatusCreated},
+		{"POST", "new", `{"options": [{"name": "command", "value": "echo new"}]}`, server.addKeyHandler, http.StatusConflict},
+		{"POST", "bad", `{"options": [{"name": "comand", "value": "echo bad"}]}`, server.addKeyHandler, http.StatusUnprocessableEntity},
+		{"POST", "bad", `{"options": `, server.addKeyHandler, http.StatusBadRequest},
+		{"PUT", "temp", `{"name": "renamed", "options": [{"name": "command", "value": "echo renamed"}]}`, server.updateKeyHandler, http.StatusOK},
+		{"PUT", "temp", `{"options": [{"name": "command", "value": "echo temp"}]}`, server.updateKeyHandler, http.StatusNotFound},
+		{"DELETE", "new", "", server.deleteKeyHandler, http.StatusNoContent},
+		{"DELETE", "new", "", server.deleteKeyHandler, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/keys/"+tt.name, strings.NewReader(tt.body))
+		req.SetPathValue("name", tt.name)
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("expected %d for %s %s, got %d: %s", tt.code, tt.method, tt.name, rr.Code, rr.Body.String())
+		}
+	}
+
+	if key := server.Config.Keymap.FindKey("renamed"); key == nil {
+		t.Error("config was not reloaded after rename")
+	}
+
+	raw := string(server.Config.Keymap.Raw())
+	if raw != "# Temporary\n[renamed]\ncommand = echo renamed\n" {
+		t.Errorf("unexpected config file after edits:\n%s", raw)
+	}
+
+	req := httptest.NewRequest("GET", "/edit", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.editHandler).ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), `"name":"renamed"`) {
+		t.Error("edit page does not have the sections")
+	}
+}
+
+func TestSaveHandlerInvalid(t *testing.T) {
+	tmpFile := tempFile(t)
+
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	configBody := "[temp]\ncommand = echo temp\n"
+	if _, err := tmpFile.WriteString(configBody); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	form := url.Values{}
+	form.Set("content", "[temp]\ncomand = echo typo\n")
+
+	req := httptest.NewRequest("POST", "/edit", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.saveHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `data-line="2"`) || !strings.Contains(body, "comand = echo typo") {
+		t.Errorf("editor does not show the problem alongside the rejected text:\n%s", body)
+	}
+
+	if string(server.Config.Keymap.Raw()) != configBody {
+		t.Error("config was saved despite problems")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("[temp]\ncommand = echo before\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	if err := cfg.Keymap.WriteRaw([]byte("[temp]\ncommand = echo after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/edit/history", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.historyHandler).ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	body := rr.Body.String()
+	for _, want := range []string{`<span class="delete">-command = echo before</span>`, `<span class="insert">&#43;command = echo after</span>`, "Restore"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in history page:\n%s", want, body)
+		}
+	}
+
+	revisions, err := cfg.Keymap.Revisions(cfg.Keymap.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   string
+		code int
+	}{
+		{"20000101-000000.000000000", http.StatusNotFound},
+		{revisions[len(revisions)-1].ID, http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/edit/history/"+tt.id, nil)
+		req.SetPathValue("id", tt.id)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.restoreHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("expected %d for %s, got %d", tt.code, tt.id, rr.Code)
+		}
+	}
+
+	if key := cfg.Keymap.FindKey("temp"); key == nil || key.Commands[0] != "echo before" {
+		t.Error("config was not restored")
+	}
+}
+
+func TestSaveHandlerStale(t *testing.T) {
+	tmpFile := tempFile(t)
+
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("[temp]\ncommand = echo temp\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	req := httptest.NewRequest("GET", "/edit", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.editHandler).ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+
+	// Another tab saves first.
+	concurrent := "[temp]\ncommand = echo other tab\n"
+	if err := cfg.Keymap.WriteRaw([]byte(concurrent)); err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(hash string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("content", "[temp]\ncommand = echo this tab\n")
+		form.Set("hash", hash)
+
+		req := httptest.NewRequest("POST", "/edit", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.saveHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = save(strings.Trim(etag, `"`))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{`<span class="delete">-command = echo other tab</span>`, "command = echo this tab", keymap.Hash([]byte(concurrent))} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %q in conflict page:\n%s", want, body)
+		}
+	}
+
+	if string(cfg.Keymap.Raw()) != concurrent {
+		t.Fatal("config was saved despite the conflict")
+	}
+
+	// Saving again from the conflict page replaces the other edit.
+	if rr = save(keymap.Hash([]byte(concurrent))); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, got %d", http.StatusSeeOther, rr.Code)
+	}
+}
+
+func TestEditIncludedFile(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	confDir := keymap.ConfDir(tmpFile.Name())
+
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll(confDir); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("[main]\ncommand = echo main\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(confDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	included := filepath.Join(confDir, "extra.ini")
+	if err := os.WriteFile(included, []byte("[extra]\ncommand = echo extra\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+	name := cfg.Keymap.FileName(included)
+
+	req := httptest.NewRequest("GET", "/edit?"+url.Values{"file": {name}}.Encode(), nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.editHandler).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, "[extra]\ncommand = echo extra") || strings.Contains(body, "echo main</textarea>") {
+		t.Fatalf("expected the included file in the editor, got %d:\n%s", rr.Code, body)
+	}
+
+	req = httptest.NewRequest("GET", "/edit?file=..%2Fserver.go", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.editHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected %d for a file outside the config, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	form := url.Values{}
+	form.Set("file", name)
+	form.Set("content", "[extra]\ncommand = echo changed\n")
+
+	req = httptest.NewRequest("POST", "/edit", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.saveHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected %d, got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	if string(cfg.Keymap.ReadFile(included)) != "[extra]\ncommand = echo changed\n" {
+		t.Fatal("the included file was not saved")
+	}
+
+	if string(cfg.Keymap.Raw()) != "[main]\ncommand = echo main\n" {
+		t.Fatal("the main file was changed")
+	}
+
+	if key := cfg.Keymap.FindKeyByName("extra"); key == nil || key.Commands[0] != "echo changed" {
+		t.Fatal("the keymap was not reloaded")
+	}
+
+	// A key that is already in the main file can't be added again.
+	form.Set("content", "[extra]\ncommand = echo changed\n\n[main]\ncommand = echo again\n")
+	req = httptest.NewRequest("POST", "/edit", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.saveHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestTriggerTemplate(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-template.ini")
+	req := httptest.NewRequest("POST", "/trigger?source=browser&who="+url.QueryEscape("world; echo injected"), nil)
+	req.SetPathValue("key", "test")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.triggerHandler).ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	if body := rr.Body.String(); body != "hello world; echo injected from browser\n" {
+		t.Errorf("unexpected output %q", body)
+	}
+}
+
+func TestTriggerParams(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		query string
+		form  string
+		code  int
+		body  string
+	}{
+		{"who=me", "", http.StatusOK, "me at 50\n"},
+		{"", "who=" + url.QueryEscape("$(echo injected)") + "&level=7", http.StatusOK, "$(echo injected) at 7\n"},
+		{"level=7", "", http.StatusBadRequest, "who is required: invalid param\n"},
+		{"who=me&level=101", "", http.StatusBadRequest, "level must be from 0 to 100: invalid param\n"},
+		{"who=me&level=" + url.QueryEscape("1; reboot"), "", http.StatusBadRequest, "level must be a whole number: invalid param\n"},
+	}
+
+	for _, tt := range tests {
+		server := serverFixture(t, "key-param.ini")
+		req := httptest.NewRequest("POST", "/trigger?"+tt.query, strings.NewReader(tt.form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("key", "test")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.triggerHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code || rr.Body.String() != tt.body {
+			t.Errorf("%s%s: expected %d %q, got %d %q", tt.query, tt.form, tt.code, tt.body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestTriggerProbe(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-probe.ini")
+	req := httptest.NewRequest("POST", "/trigger", nil)
+	req.SetPathValue("key", "test")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.triggerHandler)
+	handler.ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	body := rr.Body.String()
+	if body != "hello 2\n" {
+		t.Errorf("probe did not select the second command, got '%s'", body)
+	}
+}
+
+func TestStateHandler(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		fixture string
+		key     string
+		state   string
+		code    int
+	}{
+		{"key-probe.ini", "test", "state2", http.StatusOK},
+		{"key-roll.ini", "test", "state1", http.StatusOK},
+		{"key-single.ini", "test", "", http.StatusNotFound},
+		{"key-probe.ini", "invalid", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		server := serverFixture(t, tt.fixture)
+		req := httptest.NewRequest("GET", "/state", nil)
+		req.SetPathValue("key", tt.key)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.stateHandler)
+		handler.ServeHTTP(rr, req)
+		failIfServerError(t, rr)
+
+		if rr.Code != tt.code {
+			t.Errorf("%s expected %d, got %d", tt.fixture, tt.code, rr.Code)
+		}
+
+		if state := rr.Header().Get("X-Keys-State"); state != tt.state {
+			t.Errorf("%s expected state '%s', got '%s'", tt.fixture, tt.state, state)
+		}
+	}
+}
+
+func TestTriggerConfirm(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-confirm.ini")
+
+	tests := []struct {
+		query string
+		token string
+		code  int
+	}{
+		{"", "", http.StatusConflict},
+		{"?confirm=1", "", http.StatusOK},
+		{"?source=browser", "", http.StatusConflict},
+		{"?source=keyboard", server.Config.TriggerToken, http.StatusConflict},
+		{"?source=keyboard", server.Config.TriggerToken, http.StatusOK},
+		{"?source=keyboard", "", http.StatusConflict},
+		{"?source=keyboard", "", http.StatusConflict},
+		{"?source=keyboard", server.Config.TriggerToken, http.StatusConflict},
+		{"?source=schedule", "", http.StatusConflict},
+		{"?source=schedule", server.Config.TriggerToken, http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("POST", "/trigger"+tt.query, nil)
+		req.Header.Set(config.TokenHeader, tt.token)
+		req.SetPathValue("key", "hi")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.triggerHandler)
+		handler.ServeHTTP(rr, req)
+		failIfServerError(t, rr)
+
+		if rr.Code != tt.code {
+			t.Errorf("request %d with query '%s' expected %d, got %d", i, tt.query, tt.code, rr.Code)
+		}
+	}
+}
+
+func TestRunScheduled(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-schedule.ini")
+
+	var triggered []string
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(config.TokenHeader) != server.Config.TriggerToken {
+			t.Error("expected the scheduler to send the trigger token")
+		}
+		triggered = append(triggered, r.URL.RequestURI())
+	}))
+	t.Cleanup(listener.Close)
+	server.Config.TriggerUrl = listener.URL
+
+	attacker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected scheduled runs to stay on the trigger url, got %s %s", r.Host, r.URL)
+	}))
+	t.Cleanup(attacker.Close)
+
+	// A client claiming to be forwarded moves the public url, not where the
+	// token is sent.
+	req := httptest.NewRequest("GET", "/version", nil)
+	req.Header.Set("X-Forwarded-Proto", "http")
+	req.Header.Set("X-Forwarded-Host", strings.TrimPrefix(attacker.URL, "http://"))
+	serverHeaders(http.HandlerFunc(server.versionHandler), server.Config).ServeHTTP(httptest.NewRecorder(), req)
+
+	server.Config.SetKeyboardLocked(true)
+	server.runScheduled("test")
+
+	server.Config.SetKeyboardLocked(false)
+	server.runScheduled("test")
+
+	if len(triggered) != 1 || triggered[0] != "/trigger/test?source=schedule" {
+		t.Errorf("expected one scheduled run while unlocked, got %v", triggered)
+	}
+}
+
+// Run with -race to catch keys shared between requests without locking.
+func TestTriggerParallel(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	content := "max_concurrent = 100\n\n" +
+		"[toggle]\nphysical_key = t\ncommand = echo on\ncommand = echo off\nstate = on\nstate = off\n\n" +
+		"[probed]\nphysical_key = p\ncommand = echo on\ncommand = echo off\nstate = on\nstate = off\nprobe = echo off\n\n" +
+		"[confirm]\nphysical_key = c\ncommand = echo confirmed\nrequire_confirm = true\n"
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	var wg sync.WaitGroup
+	for i := range 60 {
+		target := []string{"toggle", "t", "probed", "p", "confirm", "c"}[i%6]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest("POST", "/trigger?source=keyboard", nil)
+			req.SetPathValue("key", target)
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(server.triggerHandler).ServeHTTP(rr, req)
+
+			if rr.Code == http.StatusInternalServerError || rr.Code == http.StatusNotFound {
+				t.Errorf("triggering %s returned %d", target, rr.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if state := server.Config.Keymap.FindKey("toggle").State(); state != "on" {
+		t.Errorf("expected an even number of toggles to end on, got %s", state)
+	}
+}
+
+func TestTriggerSourceIsChecked(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	content := "[source]\ncommand = echo {{.Source}}\n\n" +
+		"[network]\ncommand = cat /proc/net/dev\nsandbox = no_network\nsandbox_sources = api\n"
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+
+	trigger := func(key string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/trigger?source=keyboard", nil)
+		req.Header.Set(config.TokenHeader, token)
+		req.SetPathValue("key", key)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.triggerHandler).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if body := trigger("source", "").Body.String(); body != "api\n" {
+		t.Errorf("expected a keyboard request without the token to count as api, got %q", body)
+	}
+
+	if body := trigger("source", cfg.TriggerToken).Body.String(); body != "keyboard\n" {
+		t.Errorf("expected a keyboard request with the token to count as keyboard, got %q", body)
+	}
+
+	rr := trigger("network", "wrong")
+	if rr.Code == http.StatusInternalServerError && strings.Contains(rr.Body.String(), "operation not permitted") {
+		t.Skip("user namespaces are not available")
+	}
+
+	// Only the loopback interface exists in a new network namespace.
+	for line := range strings.Lines(rr.Body.String()) {
+		name, _, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && name != "lo" && !strings.Contains(name, "|") {
+			t.Errorf("expected the sandbox for a keyboard request without the token, saw network interface %s", name)
+		}
+	}
+}
+
+func TestTriggerThrottle(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-cooldown.ini")
+
+	tests := []struct {
+		code int
+	}{
+		{http.StatusOK},
+		{http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("POST", "/trigger", nil)
+		req.SetPathValue("key", "test")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.triggerHandler)
+		handler.ServeHTTP(rr, req)
+		failIfServerError(t, rr)
+
+		if rr.Code != tt.code {
+			t.Errorf("request %d expected %d, got %d", i, tt.code, rr.Code)
+		}
+	}
+}
+
+func TestCancelRunning(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-sleep.ini")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest("POST", "/trigger", nil)
+		req.SetPathValue("key", "test")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.triggerHandler).ServeHTTP(rr, req)
+		done <- rr
+	}()
+
+	var jobs []job.Job
+	for range 100 {
+		jobs = server.Config.Jobs.Running()
+		if len(jobs) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(jobs) != 1 {
+		t.Fatalf("expected 1 running job, got %d", len(jobs))
+	}
+
+	req := httptest.NewRequest("GET", "/running", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.runningHandler).ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	if !strings.Contains(rr.Body.String(), fmt.Sprintf(`"id":"%s"`, jobs[0].ID)) {
+		t.Errorf("running job missing from list: %s", rr.Body.String())
+	}
+
+	tests := []struct {
+		id   string
+		code int
+	}{
+		{"invalid", http.StatusNotFound},
+		{jobs[0].ID, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("DELETE", "/running", nil)
+		req.SetPathValue("id", tt.id)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.cancelHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("cancelling '%s' expected %d, got %d", tt.id, tt.code, rr.Code)
+		}
+	}
+
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusConflict {
+			t.Errorf("cancelled trigger expected %d, got %d", http.StatusConflict, rr.Code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("trigger did not return after being cancelled")
+	}
+}
+
+func TestTriggerTimeout(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-timeout.ini")
+	req := httptest.NewRequest("POST", "/trigger", nil)
+	req.SetPathValue("key", "test")
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.triggerHandler)
+	handler.ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected %d, got %d", http.StatusGatewayTimeout, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "timed out") {
+		t.Errorf("timeout was not reported: '%s'", rr.Body.String())
+	}
+}
+
+func TestTriggerAsync(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	server := serverFixture(t, "key-roll.ini")
+	req := httptest.NewRequest("POST", "/trigger?async=1", nil)
+	req.SetPathValue("key", "test")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.triggerHandler).ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+
+	location := rr.Header().Get("Location")
+	id := strings.TrimPrefix(location, "/jobs/")
+	if id == location || id == "" {
+		t.Fatalf("unexpected job location '%s'", location)
+	}
+
+	var j job.Job
+	for range 100 {
+		req := httptest.NewRequest("GET", location, nil)
+		req.SetPathValue("id", id)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.jobHandler).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("job lookup expected %d, got %d", http.StatusOK, rr.Code)
+		}
+
+		if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+			t.Fatal(err)
+		}
+
+		if j.Status != job.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if j.Status != job.Succeeded || j.Output != "hello\n" || j.State != "state2" {
+		t.Errorf("unexpected job result: %+v", j)
+	}
+
+	req = httptest.NewRequest("GET", "/jobs/invalid", nil)
+	req.SetPathValue("id", "invalid")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(server.jobHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown job expected %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestMute(t *testing.T) {
+	t.Cleanup(resetLogger)
+	log.SetOutput(io.Discard)
+
+	tmpFile := tempFile(t)
+	t.Cleanup(func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := tmpFile.WriteString("[temp]\ncommand = echo temp\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := Server{":4004", cfg}
+	t.Cleanup(sound.Unmute)
+
+	soundStatus := func() sound.Status {
+		req := httptest.NewRequest("GET", "/sound", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.soundHandler).ServeHTTP(rr, req)
+		failIfServerError(t, rr)
+
+		var status sound.Status
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	tests := []struct {
+		action string
+		code   int
+		muted  bool
+	}{
+		{action: "mute", code: http.StatusOK, muted: true},
+		{action: "unmute", code: http.StatusOK, muted: false},
+		{action: "louder", code: http.StatusNotFound, muted: false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/sound/"+tt.action, nil)
+		req.SetPathValue("action", tt.action)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.muteHandler).ServeHTTP(rr, req)
+
+		if rr.Code != tt.code {
+			t.Errorf("expected %d for %s, got %d", tt.code, tt.action, rr.Code)
+		}
+
+		if status := soundStatus(); status.Muted != tt.muted {
+			t.Errorf("expected muted=%v after %s, got %v", tt.muted, tt.action, status.Muted)
+		}
+
+		if server.Config.Keymap.SoundAllowed == tt.muted {
+			t.Errorf("expected SoundAllowed %v after %s", !tt.muted, tt.action)
+		}
+	}
+
+	form := url.Values{}
+	form.Set("sound", "off")
+	req := httptest.NewRequest("POST", "/settings/sound", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(server.soundSettingHandler).ServeHTTP(rr, req)
+	failIfServerError(t, rr)
+
+	if !soundStatus().Muted {
+		t.Error("expected turning sound off in the settings to mute it")
+	}
+
+	sound.Unmute()
+	reloaded, err := config.NewConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.ConfigureSound()
+
+	if !soundStatus().Muted {
+		t.Error("expected the saved setting to mute sound when it is configured")
+	}
+}
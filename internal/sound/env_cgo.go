@@ -0,0 +1,35 @@
+//go:build cgo
+
+package sound
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// setBackendEnv sets or unsets a variable in the C library's environment,
+// which is what ALSA and the sound servers read. Go keeps its own copy of
+// the environment for the commands it starts, and this leaves that alone.
+func setBackendEnv(name string, value string, set bool) error {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	if !set {
+		if C.unsetenv(cName) != 0 {
+			return fmt.Errorf("could not unset %s for the audio backend", name)
+		}
+		return nil
+	}
+
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+
+	if C.setenv(cName, cValue, 1) != 0 {
+		return fmt.Errorf("could not set %s for the audio backend", name)
+	}
+
+	return nil
+}
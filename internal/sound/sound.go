@@ -2,12 +2,24 @@ package sound
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"keys/internal/asset"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/effects"
+	"github.com/gopxl/beep/generators"
+	"github.com/gopxl/beep/mp3"
 	"github.com/gopxl/beep/speaker"
 	"github.com/gopxl/beep/vorbis"
+	"github.com/gopxl/beep/wav"
 )
 
 type Name int
@@ -19,11 +31,44 @@ const (
 	Down
 	Lock
 	Unlock
+	Prompt
 )
 
+// Everything is resampled to one rate when it is loaded so that sounds
+// from different sources play at the right speed through one speaker.
+const mixerRate = beep.SampleRate(48000)
+
+var mixerFormat = beep.Format{SampleRate: mixerRate, NumChannels: 2, Precision: 2}
+
+var ErrUnsupportedFormat = errors.New("unsupported sound format")
+
+// Settings are the sound options from the configuration. Volumes are
+// percentages. The volume of an individual sound is relative to the overall
+// volume.
+type Settings struct {
+	Dir     string
+	Files   map[Name]string
+	Volume  int
+	Volumes map[Name]int
+	Device  string
+	Muted   bool
+}
+
+type Status struct {
+	Muted  bool   `json:"muted"`
+	Volume int    `json:"volume"`
+	Device string `json:"device"`
+}
+
 var (
-	sounds = make(map[Name]string)
-	cache  = make(map[Name]*beep.Buffer)
+	sounds      = make(map[Name]string)
+	tones       = make(map[Name][]float64)
+	cache       = make(map[Name]*beep.Buffer)
+	fileCache   = make(map[string]*beep.Buffer)
+	settings    = Settings{Volume: 100}
+	muted       bool
+	speakerInit bool
+	mu          sync.Mutex
 )
 
 func init() {
@@ -31,55 +76,315 @@ func init() {
 	sounds[Error] = "assets/alert_error-03.ogg"
 	sounds[Lock] = "assets/ui_lock.ogg"
 	sounds[Unlock] = "assets/ui_unlock.ogg"
+
+	tones[Prompt] = []float64{880, 660, 880}
+}
+
+// Configure replaces built-in sounds with files on disk and sets volumes,
+// the output device and whether sound is muted. Relative paths, both here
+// and in PlayFile, are resolved against the directory in the settings.
+func Configure(s Settings) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The audio library can only open a speaker once, so a new device waits
+	// for a restart rather than leaving keys without sound.
+	if speakerInit && s.Device != settings.Device {
+		log.Printf("Restart keys to play sounds through %q", s.Device)
+		s.Device = settings.Device
+	}
+
+	settings = s
+	muted = s.Muted
+	settings.Files = make(map[Name]string)
+	for name, path := range s.Files {
+		if path != "" {
+			settings.Files[name] = path
+		}
+	}
+
+	clear(cache)
+	clear(fileCache)
 }
 
+// Source describes where a sound comes from, for display.
+func Source(name Name) string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if path, found := settings.Files[name]; found {
+		return resolve(path)
+	}
+
+	if _, found := tones[name]; found {
+		return "built-in tone"
+	}
+
+	return "built-in"
+}
+
+// Mute silences all sounds, including any that are playing, until Unmute
+// is called.
+func Mute() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	muted = true
+	if speakerInit {
+		speaker.Clear()
+	}
+}
+
+func Unmute() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	muted = false
+}
+
+func CurrentStatus() Status {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return Status{Muted: muted, Volume: settings.Volume, Device: settings.Device}
+}
+
+func resolve(path string) string {
+	if rest, found := strings.CutPrefix(path, "~/"); found {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+
+	if filepath.IsAbs(path) || settings.Dir == "" {
+		return path
+	}
+
+	return filepath.Join(settings.Dir, path)
+}
+
+// volume combines the overall volume with that of an individual sound.
+// Sounds without a volume of their own play at the overall volume.
+func volume(individual int, found bool) int {
+	if !found {
+		individual = 100
+	}
+
+	return settings.Volume * individual / 100
+}
+
+// Volume is a percentage, mapped onto beep's logarithmic scale so that 50
+// sounds about half as loud as 100.
+func withVolume(streamer beep.Streamer, volume int) beep.Streamer {
+	if volume >= 100 {
+		return streamer
+	}
+
+	return &effects.Volume{
+		Streamer: streamer,
+		Base:     2,
+		Volume:   math.Log2(float64(max(volume, 1)) / 100),
+		Silent:   volume <= 0,
+	}
+}
+
+// A custom file that cannot be loaded falls back to the built-in sound so
+// that a typo in the configuration doesn't silence feedback. The error is
+// still returned.
 func load(name Name) error {
 	if _, found := cache[name]; found {
 		return nil
 	}
 
+	var buffer *beep.Buffer
+	var customErr, err error
+
+	if path, found := settings.Files[name]; found {
+		buffer, customErr = decodeFile(resolve(path))
+	}
+
+	if buffer == nil {
+		if frequencies, found := tones[name]; found {
+			buffer, err = loadTone(frequencies)
+		} else {
+			buffer, err = loadAsset(name)
+		}
+	}
+
+	if err != nil {
+		return errors.Join(customErr, err)
+	}
+
+	cache[name] = buffer
+	return customErr
+}
+
+// The output device is picked through the environment because the audio
+// library always opens the default device. ALSA_CARD selects a card when
+// ALSA is used directly, and PULSE_SINK and PIPEWIRE_NODE select an output
+// when the default device is routed to a sound server. They are only set
+// for the audio backend, so that commands started by keys play through
+// whatever they would have otherwise.
+var deviceVariables = []string{"ALSA_CARD", "PULSE_SINK", "PIPEWIRE_NODE"}
+
+func initSpeaker() error {
+	if speakerInit {
+		return nil
+	}
+
+	// Without a device of its own the backend gets the variables the
+	// process started with, undoing any device set before.
+	for _, variable := range deviceVariables {
+		value, found := os.LookupEnv(variable)
+		if settings.Device != "" {
+			value, found = settings.Device, true
+		}
+
+		if err := setBackendEnv(variable, value, found); err != nil {
+			return err
+		}
+	}
+
+	if err := speaker.Init(mixerRate, mixerRate.N(time.Second/30)); err != nil {
+		return err
+	}
+
+	speakerInit = true
+	return nil
+}
+
+// play must be called with mu held.
+func play(buffer *beep.Buffer, percent int) error {
+	if muted {
+		return nil
+	}
+
+	if err := initSpeaker(); err != nil {
+		return err
+	}
+
+	speaker.Play(withVolume(buffer.Streamer(0, buffer.Len()), percent))
+	return nil
+}
+
+func loadAsset(name Name) (*beep.Buffer, error) {
 	path, found := sounds[name]
 	if !found {
-		return errors.New("unknown sound")
+		return nil, errors.New("unknown sound")
 	}
 
 	b, err := asset.AssetFS.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	streamer, format, err := vorbis.Decode(b)
+	return decode(path, b)
+}
+
+func decodeFile(path string) (*beep.Buffer, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	buffer := beep.NewBuffer(format)
-	buffer.Append(streamer)
-	err = streamer.Close()
+	buffer, err := decode(path, f)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
-	if len(cache) == 0 {
-		if err = speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/30)); err != nil {
-			return err
+	return buffer, nil
+}
+
+// Decoders are picked by file extension.
+func decode(path string, rc io.ReadCloser) (*beep.Buffer, error) {
+	var streamer beep.StreamSeekCloser
+	var format beep.Format
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ogg", ".oga":
+		streamer, format, err = vorbis.Decode(rc)
+	case ".wav":
+		streamer, format, err = wav.Decode(rc)
+	case ".mp3":
+		streamer, format, err = mp3.Decode(rc)
+	default:
+		rc.Close()
+		return nil, fmt.Errorf("%w %q, use ogg, wav or mp3", ErrUnsupportedFormat, filepath.Ext(path))
+	}
+
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	buffer := beep.NewBuffer(mixerFormat)
+	if format.SampleRate == mixerRate {
+		buffer.Append(streamer)
+	} else {
+		buffer.Append(beep.Resample(4, format.SampleRate, mixerRate, streamer))
+	}
+
+	if err = streamer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer, nil
+}
+
+// Tones are short sine beeps for cues that have no recorded sound.
+func loadTone(frequencies []float64) (*beep.Buffer, error) {
+	format := mixerFormat
+	buffer := beep.NewBuffer(format)
+
+	for _, frequency := range frequencies {
+		tone, err := generators.SineTone(format.SampleRate, frequency)
+		if err != nil {
+			return nil, err
 		}
+
+		buffer.Append(&effects.Gain{
+			Streamer: beep.Take(format.SampleRate.N(120*time.Millisecond), tone),
+			Gain:     -0.75,
+		})
+		buffer.Append(generators.Silence(format.SampleRate.N(60 * time.Millisecond)))
 	}
 
-	cache[name] = buffer
-	return nil
+	return buffer, nil
 }
 
 func Play(name Name) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	err := load(name)
 	if buffer, found := cache[name]; found {
-		stream := buffer.Streamer(0, buffer.Len())
-		speaker.Play(stream)
-		return nil
+		percent, found := settings.Volumes[name]
+		if playErr := play(buffer, volume(percent, found)); playErr != nil {
+			return playErr
+		}
 	}
 
-	if err := load(name); err != nil {
-		return err
+	return err
+}
+
+// PlayFile plays a sound file such as the one given to a key, caching it
+// like the built-in sounds. The volume is relative to the overall volume.
+func PlayFile(path string, percent int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	path = resolve(path)
+
+	buffer, found := fileCache[path]
+	if !found {
+		var err error
+		if buffer, err = decodeFile(path); err != nil {
+			return err
+		}
+
+		fileCache[path] = buffer
 	}
-	return Play(name)
 
+	return play(buffer, volume(percent, true))
 }
@@ -1,7 +1,19 @@
 package sound
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gopxl/beep"
+	"github.com/gopxl/beep/generators"
+	"github.com/gopxl/beep/wav"
 )
 
 func TestSpeakerInit(t *testing.T) {
@@ -34,3 +46,247 @@ func TestPlay(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLoadTone(t *testing.T) {
+	buffer, err := loadTone(tones[Prompt])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() == 0 {
+		t.Fatal("Tone buffer is empty")
+	}
+
+	if buffer.Format().SampleRate != mixerRate {
+		t.Errorf("Unexpected tone sample rate %d", buffer.Format().SampleRate)
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	tone, err := loadTone(tones[Prompt])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "prompt.wav")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wav.Encode(f, tone.Streamer(0, tone.Len()), tone.Format()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	buffer, err := decodeFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() != tone.Len() {
+		t.Errorf("Expected %d samples, got %d", tone.Len(), buffer.Len())
+	}
+
+	format := beep.Format{SampleRate: mixerRate / 2, NumChannels: 1, Precision: 2}
+	half := filepath.Join(t.TempDir(), "half.wav")
+	f, err = os.Create(half)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wav.Encode(f, beep.Take(format.SampleRate.N(time.Second), generators.Silence(-1)), format); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	resampled, err := decodeFile(half)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resampled.Format().SampleRate != mixerRate {
+		t.Errorf("File was not resampled, got %d", resampled.Format().SampleRate)
+	}
+
+	if seconds := mixerRate.D(resampled.Len()); seconds < 990*time.Millisecond || seconds > time.Second {
+		t.Errorf("Resampling changed the length of the sound to %s", seconds)
+	}
+
+	// Silent MPEG-1 Layer III frames at 128 kbit/s and 44.1 kHz.
+	frame := append([]byte{0xff, 0xfb, 0x90, 0x00}, make([]byte, 413)...)
+	mp3 := filepath.Join(t.TempDir(), "prompt.mp3")
+	if err := os.WriteFile(mp3, bytes.Repeat(frame, 40), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeFile(mp3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Format().SampleRate != mixerRate || decoded.Len() == 0 {
+		t.Errorf("Unexpected mp3 buffer of %d samples at %d", decoded.Len(), decoded.Format().SampleRate)
+	}
+
+	flac := filepath.Join(t.TempDir(), "prompt.flac")
+	if err := os.WriteFile(flac, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decodeFile(flac); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected unsupported format error, got %v", err)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() {
+		Configure(Settings{Volume: 100})
+	})
+
+	Configure(Settings{
+		Dir: "/etc/keys",
+		Files: map[Name]string{
+			Confirmation: "done.ogg",
+			Error:        "/usr/share/sounds/error.wav",
+			Lock:         "",
+		},
+		Volume: 80,
+	})
+
+	tests := []struct {
+		name   Name
+		source string
+	}{
+		{name: Confirmation, source: "/etc/keys/done.ogg"},
+		{name: Error, source: "/usr/share/sounds/error.wav"},
+		{name: Lock, source: "built-in"},
+		{name: Prompt, source: "built-in tone"},
+	}
+
+	for _, tt := range tests {
+		if source := Source(tt.name); source != tt.source {
+			t.Errorf("Expected %s, got %s", tt.source, source)
+		}
+	}
+
+	if volume(50, true) != 40 {
+		t.Errorf("Individual volume should be relative to the overall volume, got %d", volume(50, true))
+	}
+
+	if volume(0, false) != 80 {
+		t.Errorf("Sounds without a volume should use the overall volume, got %d", volume(0, false))
+	}
+
+	Mute()
+	if !CurrentStatus().Muted {
+		t.Error("Sound was not muted")
+	}
+
+	Unmute()
+	if status := CurrentStatus(); status.Muted || status.Volume != 80 {
+		t.Errorf("Unexpected status after unmuting: %+v", status)
+	}
+}
+
+func TestDeviceIsNotInherited(t *testing.T) {
+	t.Cleanup(func() {
+		Configure(Settings{Volume: 100})
+		for _, variable := range deviceVariables {
+			value, found := os.LookupEnv(variable)
+			_ = setBackendEnv(variable, value, found)
+		}
+	})
+
+	Configure(Settings{Volume: 100, Device: "keys-test-device"})
+
+	mu.Lock()
+	// The speaker may not open here, but the device is set for it first.
+	_ = initSpeaker()
+	mu.Unlock()
+
+	for _, variable := range deviceVariables {
+		if os.Getenv(variable) == "keys-test-device" {
+			t.Errorf("%s was set for the whole process", variable)
+		}
+	}
+
+	out, err := exec.Command("env").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(out, []byte("keys-test-device")) {
+		t.Errorf("Commands inherit the sound device:\n%s", out)
+	}
+}
+
+func TestDeviceChangeKeepsSpeaker(t *testing.T) {
+	mu.Lock()
+	wasInit, device := speakerInit, settings.Device
+	speakerInit, settings.Device = true, "keys-test-device"
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		speakerInit, settings.Device = wasInit, device
+		mu.Unlock()
+		Configure(Settings{Volume: 100, Device: device})
+	})
+
+	Configure(Settings{Volume: 100, Device: "keys-other-device"})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !speakerInit {
+		t.Error("The speaker was closed, and can't be opened again")
+	}
+
+	if settings.Device != "keys-test-device" {
+		t.Errorf("Expected the device in use to be kept, got %q", settings.Device)
+	}
+}
+
+func TestCommandSynthesizer(t *testing.T) {
+	synthesizer := CommandSynthesizer{Command: "sh", Args: []string{"-c", "tr a-z A-Z"}}
+
+	audio, err := synthesizer.Synthesize(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(audio) != "HELLO" {
+		t.Errorf("Text was not passed on stdin, got %q", audio)
+	}
+
+	failing := CommandSynthesizer{Command: "sh", Args: []string{"-c", "echo no voice >&2; exit 1"}}
+	if _, err := failing.Synthesize(context.Background(), "hello"); err == nil || !strings.Contains(err.Error(), "no voice") {
+		t.Errorf("Expected error with stderr, got %v", err)
+	}
+}
+
+func TestNewSynthesizer(t *testing.T) {
+	tests := []struct {
+		engine string
+		voice  string
+		valid  bool
+	}{
+		{engine: "espeak-ng", voice: "", valid: true},
+		{engine: "espeak-ng", voice: "en-us", valid: true},
+		{engine: "piper", voice: "", valid: false},
+		{engine: "piper", voice: "/voices/en_US-lessac-medium.onnx", valid: true},
+		{engine: "festival", voice: "", valid: false},
+	}
+
+	for _, tt := range tests {
+		_, err := NewSynthesizer(tt.engine, tt.voice)
+		if tt.valid && err != nil {
+			t.Errorf("%s with voice %q was rejected: %v", tt.engine, tt.voice, err)
+		}
+
+		if !tt.valid && err == nil {
+			t.Errorf("%s with voice %q should have been rejected", tt.engine, tt.voice)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package sound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const speechTimeout = 10 * time.Second
+
+var ErrNoSynthesizer = errors.New("text-to-speech is not configured")
+
+// A Synthesizer turns text into WAV audio. Speech is played through the
+// same speaker as the other sounds so that it doesn't compete with them for
+// the audio device.
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// CommandSynthesizer runs a program that reads text on stdin and writes WAV
+// audio to stdout.
+type CommandSynthesizer struct {
+	Command string
+	Args    []string
+}
+
+func (c CommandSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	var stderr bytes.Buffer
+
+	// #nosec G204 # because the engine is chosen from a fixed list
+	cmd := exec.CommandContext(ctx, c.Command, c.Args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = &stderr
+
+	audio, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w %s", c.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return audio, nil
+}
+
+// NewSynthesizer returns a synthesizer for one of the supported engines.
+// For espeak-ng the voice is a voice name such as en-us, for piper it is
+// the path to a voice model.
+func NewSynthesizer(engine, voice string) (Synthesizer, error) {
+	switch engine {
+	case "espeak-ng":
+		args := []string{"--stdout"}
+		if voice != "" {
+			args = append(args, "-v", voice)
+		}
+		return CommandSynthesizer{Command: "espeak-ng", Args: args}, nil
+	case "piper":
+		if voice == "" {
+			return nil, errors.New("piper needs a voice model, set speech_voice")
+		}
+		return CommandSynthesizer{Command: "piper", Args: []string{"--model", resolve(voice), "--output_file", "-"}}, nil
+	default:
+		return nil, fmt.Errorf("unknown speech engine %q", engine)
+	}
+}
+
+var (
+	synthesizer  Synthesizer
+	speechVolume = 100
+)
+
+// ConfigureSpeech sets the engine used by Say and its volume as a
+// percentage. A nil synthesizer turns speech off.
+func ConfigureSpeech(s Synthesizer, volume int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	synthesizer = s
+	speechVolume = max(0, min(volume, 100))
+}
+
+func Say(text string) error {
+	mu.Lock()
+	engine := synthesizer
+	mu.Unlock()
+
+	if engine == nil {
+		return ErrNoSynthesizer
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), speechTimeout)
+	defer cancel()
+
+	audio, err := engine.Synthesize(ctx, text)
+	if err != nil {
+		return err
+	}
+
+	buffer, err := decode("speech.wav", io.NopCloser(bytes.NewReader(audio)))
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	return play(buffer, volume(speechVolume, true))
+}
@@ -0,0 +1,102 @@
+package throttle
+
+import (
+	"errors"
+	"keys/internal/keymap"
+	"sync"
+	"time"
+)
+
+var ErrThrottled = errors.New("too many runs")
+
+type Limiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	running int
+	keys    map[string]*keyState
+}
+
+type keyState struct {
+	running   int
+	lastStart time.Time
+}
+
+func NewLimiter() *Limiter {
+	l := &Limiter{
+		keys: make(map[string]*keyState),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Keys with the queue policy wait up to their timeout for a free slot instead
+// of being rejected. The returned function releases the slot.
+func (l *Limiter) Acquire(key *keymap.Key, globalMax int) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, found := l.keys[key.Name]
+	if !found {
+		state = &keyState{}
+		l.keys[key.Name] = state
+	}
+
+	cooling := func() bool {
+		return key.Cooldown > 0 && time.Since(state.lastStart) < key.Cooldown
+	}
+
+	if cooling() {
+		return nil, ErrThrottled
+	}
+
+	full := func() bool {
+		if key.MaxConcurrent > 0 && state.running >= key.MaxConcurrent {
+			return true
+		}
+		return globalMax > 0 && l.running >= globalMax
+	}
+
+	if full() {
+		if key.Throttle != "queue" {
+			return nil, ErrThrottled
+		}
+
+		// A run may have started while this one waited.
+		if !l.wait(key.Timeout, full) || cooling() {
+			return nil, ErrThrottled
+		}
+	}
+
+	state.running++
+	state.lastStart = time.Now()
+	l.running++
+
+	return func() {
+		l.mu.Lock()
+		state.running--
+		l.running--
+		l.mu.Unlock()
+		l.cond.Broadcast()
+	}, nil
+}
+
+// Must be called with the lock held.
+func (l *Limiter) wait(timeout time.Duration, full func() bool) bool {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		l.mu.Lock()
+		expired = true
+		l.mu.Unlock()
+		l.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	for full() {
+		if expired {
+			return false
+		}
+		l.cond.Wait()
+	}
+
+	return true
+}
@@ -0,0 +1,119 @@
+package throttle
+
+import (
+	"errors"
+	"keys/internal/keymap"
+	"testing"
+	"time"
+)
+
+func TestCooldown(t *testing.T) {
+	limiter := NewLimiter()
+	key := &keymap.Key{Name: "test", Cooldown: time.Hour, Timeout: time.Second}
+
+	release, err := limiter.Acquire(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	if _, err := limiter.Acquire(key, 0); !errors.Is(err, ErrThrottled) {
+		t.Fatal("Key in cooldown was not throttled")
+	}
+}
+
+func TestCooldownAfterQueue(t *testing.T) {
+	limiter := NewLimiter()
+	key := &keymap.Key{Name: "test", MaxConcurrent: 1, Throttle: "queue", Timeout: time.Second, Cooldown: 100 * time.Millisecond}
+
+	release, err := limiter.Acquire(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both queue once the cooldown is over, and whichever is second runs in
+	// the cooldown of the first.
+	time.Sleep(150 * time.Millisecond)
+	errs := make(chan error)
+	for range 2 {
+		go func() {
+			release, err := limiter.Acquire(key, 0)
+			if err == nil {
+				release()
+			}
+			errs <- err
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	release()
+
+	throttled := 0
+	for range 2 {
+		if errors.Is(<-errs, ErrThrottled) {
+			throttled++
+		}
+	}
+
+	if throttled != 1 {
+		t.Fatalf("Expected one queued run to be throttled, got %d", throttled)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyMax    int
+		globalMax int
+	}{
+		{name: "per-key", keyMax: 1, globalMax: 0},
+		{name: "global", keyMax: 0, globalMax: 1},
+	}
+
+	for _, tt := range tests {
+		limiter := NewLimiter()
+		key := &keymap.Key{Name: "test", MaxConcurrent: tt.keyMax, Timeout: time.Second}
+
+		release, err := limiter.Acquire(key, tt.globalMax)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := limiter.Acquire(key, tt.globalMax); !errors.Is(err, ErrThrottled) {
+			t.Errorf("%s limit was not enforced", tt.name)
+		}
+
+		release()
+
+		release, err = limiter.Acquire(key, tt.globalMax)
+		if err != nil {
+			t.Errorf("%s limit was not lifted after release", tt.name)
+		} else {
+			release()
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	limiter := NewLimiter()
+	key := &keymap.Key{Name: "test", MaxConcurrent: 1, Throttle: "queue", Timeout: time.Second}
+
+	release, err := limiter.Acquire(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, release)
+
+	release2, err := limiter.Acquire(key, 0)
+	if err != nil {
+		t.Fatalf("Queued run was rejected: %v", err)
+	}
+
+	key.Timeout = 50 * time.Millisecond
+	if _, err := limiter.Acquire(key, 0); !errors.Is(err, ErrThrottled) {
+		t.Error("Queued run did not time out")
+	}
+
+	release2()
+}